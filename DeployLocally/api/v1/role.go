@@ -0,0 +1,10 @@
+package log_v1
+
+// Roles a server can have within the cluster, as servers report them in
+// Server.Role and advertise them in their serf tags. Voters take part in
+// elections and commit quorums, non-voters only replicate the log to serve
+// reads.
+const (
+	RoleVoter    = "voter"
+	RoleNonvoter = "nonvoter"
+)
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/config"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type admin struct {
+	conn   *grpc.ClientConn
+	client api.AdminClient
+}
+
+func newAdminCmd() *cobra.Command {
+	a := &admin{}
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Manage the cluster's raft membership.",
+		Long: "Manage the cluster's raft membership. Membership changes " +
+			"must be sent to the leader.",
+		PersistentPreRunE:  a.setupClient,
+		PersistentPostRunE: a.closeClient,
+	}
+	cmd.PersistentFlags().String("addr", "127.0.0.1:8400", "RPC address of the node to send the request to.")
+	cmd.PersistentFlags().String("tls-cert-file", "", "Path to client tls cert.")
+	cmd.PersistentFlags().String("tls-key-file", "", "Path to client tls key.")
+	cmd.PersistentFlags().String("tls-ca-file", "", "Path to client certificate authority.")
+	cmd.PersistentFlags().String("token", "", "Bearer token, a JWT or an API key, to authenticate with.")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "add-voter <id> <rpc-addr>",
+			Short: "Add a voter to the cluster, or promote a non-voter.",
+			Args:  cobra.ExactArgs(2),
+			RunE:  a.addVoter,
+		},
+		&cobra.Command{
+			Use:   "remove-server <id>",
+			Short: "Remove a server from the cluster.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  a.removeServer,
+		},
+		&cobra.Command{
+			Use:   "demote <id>",
+			Short: "Demote a voter to a non-voter.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  a.demoteVoter,
+		},
+		&cobra.Command{
+			Use:   "transfer-leadership [id]",
+			Short: "Hand leadership over to the given voter, or to any voter.",
+			Args:  cobra.MaximumNArgs(1),
+			RunE:  a.transferLeadership,
+		},
+		&cobra.Command{
+			Use:   "peers",
+			Short: "List the raft peers and how far behind they are.",
+			Args:  cobra.NoArgs,
+			RunE:  a.listPeers,
+		},
+		&cobra.Command{
+			Use:   "stats",
+			Short: "Print the node's raft stats.",
+			Args:  cobra.NoArgs,
+			RunE:  a.stats,
+		},
+		&cobra.Command{
+			Use:   "log-info",
+			Short: "Print the node's log offsets and segments.",
+			Args:  cobra.NoArgs,
+			RunE:  a.logInfo,
+		},
+		newKeyringCmd(a),
+	)
+	return cmd
+}
+
+func (a *admin) setupClient(cmd *cobra.Command, args []string) error {
+	addr, err := cmd.Flags().GetString("addr")
+	if err != nil {
+		return err
+	}
+	tlsConfig := config.TLSConfig{}
+	if tlsConfig.CertFile, err = cmd.Flags().GetString("tls-cert-file"); err != nil {
+		return err
+	}
+	if tlsConfig.KeyFile, err = cmd.Flags().GetString("tls-key-file"); err != nil {
+		return err
+	}
+	if tlsConfig.CAFile, err = cmd.Flags().GetString("tls-ca-file"); err != nil {
+		return err
+	}
+
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if tlsConfig.CAFile != "" {
+		clientTLSConfig, err := config.SetupTLSConfig(tlsConfig)
+		if err != nil {
+			return err
+		}
+		opts = []grpc.DialOption{
+			grpc.WithTransportCredentials(credentials.NewTLS(clientTLSConfig)),
+		}
+	}
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		if tlsConfig.CAFile == "" {
+			return fmt.Errorf("tokens are only sent over TLS, set --tls-ca-file")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(token)))
+	}
+	a.conn, err = grpc.Dial(addr, opts...)
+	if err != nil {
+		return err
+	}
+	a.client = api.NewAdminClient(a.conn)
+	return nil
+}
+
+// bearerToken sends the token in each request's authorization metadata.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity keeps the token off plaintext connections, where
+// anyone on the way could read it and authenticate as its subject.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+func (a *admin) closeClient(cmd *cobra.Command, args []string) error {
+	return a.conn.Close()
+}
+
+func (a *admin) addVoter(cmd *cobra.Command, args []string) error {
+	_, err := a.client.AddVoter(context.Background(), &api.AddVoterRequest{
+		Id:      args[0],
+		RpcAddr: args[1],
+	})
+	return err
+}
+
+func (a *admin) removeServer(cmd *cobra.Command, args []string) error {
+	_, err := a.client.RemoveServer(context.Background(), &api.RemoveServerRequest{
+		Id: args[0],
+	})
+	return err
+}
+
+func (a *admin) demoteVoter(cmd *cobra.Command, args []string) error {
+	_, err := a.client.DemoteVoter(context.Background(), &api.DemoteVoterRequest{
+		Id: args[0],
+	})
+	return err
+}
+
+func (a *admin) transferLeadership(cmd *cobra.Command, args []string) error {
+	req := &api.TransferLeadershipRequest{}
+	if len(args) == 1 {
+		req.Id = args[0]
+	}
+	_, err := a.client.TransferLeadership(context.Background(), req)
+	return err
+}
+
+func (a *admin) listPeers(cmd *cobra.Command, args []string) error {
+	res, err := a.client.ListPeers(context.Background(), &api.ListPeersRequest{})
+	if err != nil {
+		return err
+	}
+	fmt.Printf(
+		"state: %s, last index: %d, applied index: %d\n\n",
+		res.State,
+		res.LastIndex,
+		res.AppliedIndex,
+	)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tROLE\tLEADER\tMATCH INDEX\tLAG")
+	for _, peer := range res.Peers {
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%t\t%d\t%d\n",
+			peer.Id,
+			peer.RpcAddr,
+			peer.Role,
+			peer.IsLeader,
+			peer.MatchIndex,
+			peer.Lag,
+		)
+	}
+	return w.Flush()
+}
+
+func (a *admin) stats(cmd *cobra.Command, args []string) error {
+	res, err := a.client.GetStats(context.Background(), &api.GetStatsRequest{})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(res.Stats))
+	for k := range res.Stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s: %s\n", k, res.Stats[k])
+	}
+	return nil
+}
+
+func (a *admin) logInfo(cmd *cobra.Command, args []string) error {
+	res, err := api.NewLogClient(a.conn).GetLogInfo(
+		context.Background(),
+		&api.GetLogInfoRequest{Segments: true},
+	)
+	if err != nil {
+		return err
+	}
+	fmt.Printf(
+		"lowest offset: %d, highest offset: %d, commit index: %d\n"+
+			"segments: %d, total bytes: %d\n\n",
+		res.LowestOffset,
+		res.HighestOffset,
+		res.CommitIndex,
+		res.SegmentCount,
+		res.TotalBytes,
+	)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "BASE OFFSET\tNEXT OFFSET\tSTORE SIZE\tINDEX SIZE")
+	for _, segment := range res.Segments {
+		fmt.Fprintf(
+			w,
+			"%d\t%d\t%d\t%d\n",
+			segment.BaseOffset,
+			segment.NextOffset,
+			segment.StoreSize,
+			segment.IndexSize,
+		)
+	}
+	return w.Flush()
+}
+
+func newKeyringCmd(a *admin) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "keyring",
+		Short: "Manage the keys encrypting gossip across the cluster.",
+		Long: "Manage the keys encrypting gossip across the cluster. To rotate " +
+			"the key, install the new key, use it, then remove the old key.",
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "install <key>",
+			Short: "Install the key on every server.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  a.installKey,
+		},
+		&cobra.Command{
+			Use:   "use <key>",
+			Short: "Encrypt gossip with the installed key.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  a.useKey,
+		},
+		&cobra.Command{
+			Use:   "remove <key>",
+			Short: "Remove the key from every server.",
+			Args:  cobra.ExactArgs(1),
+			RunE:  a.removeKey,
+		},
+		&cobra.Command{
+			Use:   "list",
+			Short: "List the installed keys and how many servers have them.",
+			Args:  cobra.NoArgs,
+			RunE:  a.listKeys,
+		},
+	)
+	return cmd
+}
+
+func (a *admin) installKey(cmd *cobra.Command, args []string) error {
+	_, err := a.client.InstallKey(context.Background(), &api.InstallKeyRequest{
+		Key: args[0],
+	})
+	return err
+}
+
+func (a *admin) useKey(cmd *cobra.Command, args []string) error {
+	_, err := a.client.UseKey(context.Background(), &api.UseKeyRequest{
+		Key: args[0],
+	})
+	return err
+}
+
+func (a *admin) removeKey(cmd *cobra.Command, args []string) error {
+	_, err := a.client.RemoveKey(context.Background(), &api.RemoveKeyRequest{
+		Key: args[0],
+	})
+	return err
+}
+
+func (a *admin) listKeys(cmd *cobra.Command, args []string) error {
+	res, err := a.client.ListKeys(context.Background(), &api.ListKeysRequest{})
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(res.Keys))
+	for k := range res.Keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Printf("%s [%d/%d]\n", k, res.Keys[k], res.NumServers)
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"path"
 	"syscall"
+	"time"
 
 	"github.com/madalosso/proglog/internal/agent"
 	"github.com/madalosso/proglog/internal/config"
@@ -42,19 +43,51 @@ func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
 
 	viper.SetConfigFile(configFile)
 
-	// if err = viper.ReadInConfig(); err != nil {
-	// 	if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-	// 		fmt.Println("error reading file")
-	// 		return err
-	// 	}
-	// }
+	if err = viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return fmt.Errorf("reading config file %q: %w", configFile, err)
+		}
+	}
 	c.cfg.DataDir = viper.GetString("data-dir")
 	c.cfg.NodeName = viper.GetString("node-name")
 	c.cfg.BindAddr = viper.GetString("bind-addr")
 	c.cfg.RPCPort = viper.GetInt("rpc-port")
 	c.cfg.StartJoinAddrs = viper.GetStringSlice("start-join-addrs")
 	c.cfg.Bootstrap = viper.GetBool("bootstrap")
-	c.cfg.ACLModelFile = viper.GetString("acl-mode-file")
+	c.cfg.BootstrapExpect = viper.GetInt("bootstrap-expect")
+	c.cfg.Nonvoter = viper.GetBool("nonvoter")
+	c.cfg.RaftHeartbeatTimeout = viper.GetDuration("raft-heartbeat-timeout")
+	c.cfg.RaftElectionTimeout = viper.GetDuration("raft-election-timeout")
+	c.cfg.RaftLeaderLeaseTimeout = viper.GetDuration("raft-leader-lease-timeout")
+	c.cfg.RaftCommitTimeout = viper.GetDuration("raft-commit-timeout")
+	c.cfg.RaftTransportMaxPool = viper.GetInt("raft-transport-max-pool")
+	c.cfg.RaftTransportTimeout = viper.GetDuration("raft-transport-timeout")
+	c.cfg.RaftApplyTimeout = viper.GetDuration("raft-apply-timeout")
+	c.cfg.RaftMaxAppendEntries = viper.GetInt("raft-max-append-entries")
+	c.cfg.RaftBatchApply = viper.GetBool("raft-batch-apply")
+	c.cfg.RaftAppendBatchSize = viper.GetInt("raft-append-batch-size")
+	c.cfg.Autopilot = viper.GetBool("autopilot")
+	c.cfg.AutopilotDeadServerThreshold = viper.GetDuration("autopilot-dead-server-threshold")
+	c.cfg.AutopilotStabilizationTime = viper.GetDuration("autopilot-stabilization-time")
+	c.cfg.AutopilotMaxVoters = viper.GetInt("autopilot-max-voters")
+	c.cfg.ReconcileInterval = viper.GetDuration("reconcile-interval")
+	c.cfg.EncryptKey = viper.GetString("encrypt")
+	c.cfg.Zone = viper.GetString("zone")
+	c.cfg.MaxConsumeWait = viper.GetDuration("max-consume-wait")
+	c.cfg.Topic = viper.GetString("topic")
+	c.cfg.KafkaPort = viper.GetInt("kafka-port")
+	c.cfg.KafkaTopic = viper.GetString("kafka-topic")
+	c.cfg.RESPPort = viper.GetInt("resp-port")
+	c.cfg.RESPStream = viper.GetString("resp-stream")
+	c.cfg.RESPPasswordFile = viper.GetString("resp-password-file")
+	c.cfg.CertSubject = viper.GetString("cert-subject")
+	c.cfg.JWKSFile = viper.GetString("jwks-file")
+	c.cfg.JWTIssuer = viper.GetString("jwt-issuer")
+	c.cfg.JWTAudience = viper.GetString("jwt-audience")
+	c.cfg.JWTSubjectClaim = viper.GetString("jwt-subject-claim")
+	c.cfg.APIKeysFile = viper.GetString("api-keys-file")
+	c.cfg.TokenOnlyClients = viper.GetBool("token-only-clients")
+	c.cfg.ACLModelFile = viper.GetString("acl-model-file")
 	c.cfg.ACLPolicyFile = viper.GetString("acl-policy-file")
 	c.cfg.ServerTLSConfig.CertFile = viper.GetString("server-tls-cert-file")
 	c.cfg.ServerTLSConfig.KeyFile = viper.GetString("server-tls-key-file")
@@ -106,6 +139,7 @@ func main() {
 	if err := setupFlags(cmd); err != nil {
 		log.Fatal(err)
 	}
+	cmd.AddCommand(newAdminCmd(), newRecoverCmd())
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
@@ -125,6 +159,43 @@ func setupFlags(cmd *cobra.Command) error {
 	cmd.Flags().Int("rpc-port", 8400, "Port for RPC clients (and Raft) connections.")
 	cmd.Flags().StringSlice("start-join-addrs", nil, "Serf addresses to join.")
 	cmd.Flags().Bool("bootstrap", false, "Bootstrap the cluster.")
+	cmd.Flags().Int("bootstrap-expect", 0, "Bootstrap the cluster once this many servers have joined.")
+	cmd.Flags().Bool("nonvoter", false, "Join the cluster as a non-voting read replica.")
+
+	cmd.Flags().Duration("raft-heartbeat-timeout", 0, "Raft heartbeat timeout, raft's default when 0.")
+	cmd.Flags().Duration("raft-election-timeout", 0, "Raft election timeout, raft's default when 0.")
+	cmd.Flags().Duration("raft-leader-lease-timeout", 0, "Raft leader lease timeout, raft's default when 0.")
+	cmd.Flags().Duration("raft-commit-timeout", 0, "Raft commit timeout, raft's default when 0.")
+	cmd.Flags().Int("raft-transport-max-pool", 5, "Raft connections pooled per peer.")
+	cmd.Flags().Duration("raft-transport-timeout", 10*time.Second, "Raft transport I/O timeout.")
+	cmd.Flags().Duration("raft-apply-timeout", 10*time.Second, "How long an append waits for Raft to take it.")
+	cmd.Flags().Int("raft-max-append-entries", 0, "Most entries Raft sends or applies at once, raft's default when 0.")
+	cmd.Flags().Bool("raft-batch-apply", false, "Let Raft commit up to raft-max-append-entries appends at once, past the apply timeout.")
+	cmd.Flags().Int("raft-append-batch-size", 64, "Most concurrent appends sharing a Raft entry.")
+
+	cmd.Flags().Bool("autopilot", false, "Promote servers once they're stable and remove dead ones.")
+	cmd.Flags().Duration("autopilot-dead-server-threshold", 5*time.Minute, "How long a server can be unreachable before autopilot removes it.")
+	cmd.Flags().Duration("autopilot-stabilization-time", 10*time.Second, "How long a server must be healthy before autopilot promotes it.")
+	cmd.Flags().Int("autopilot-max-voters", 0, "Most voters autopilot promotes servers up to, no limit when 0.")
+	cmd.Flags().Duration("reconcile-interval", time.Minute, "How often the leader makes Raft's servers match the cluster's members.")
+
+	cmd.Flags().String("encrypt", "", "Base64 encoded key encrypting gossip, only read on first start.")
+	cmd.Flags().String("zone", "", "Zone the server runs in, clients prefer reading from their zone.")
+	cmd.Flags().Duration("max-consume-wait", 30*time.Second, "Longest consumers can wait for records yet to be appended.")
+	cmd.Flags().String("topic", "proglog", "Name the log goes by, ACLs know it as topics/<topic>.")
+	cmd.Flags().Int("kafka-port", 0, "Port serving the log over the Kafka protocol, off when 0.")
+	cmd.Flags().String("kafka-topic", "", "Topic the log goes by over the Kafka protocol, topic when empty.")
+	cmd.Flags().Int("resp-port", 0, "Port serving the log as a Redis stream, off when 0.")
+	cmd.Flags().String("resp-stream", "", "Key the log goes by as a Redis stream, topic when empty.")
+	cmd.Flags().String("resp-password-file", "", "Path to the htpasswd file Redis clients AUTH against.")
+
+	cmd.Flags().String("cert-subject", "cn", "Field of client certificates RPC clients authenticate as: cn, uri, dns or email.")
+	cmd.Flags().String("jwks-file", "", "Path to the JSON Web Key Set RPC clients' bearer JWTs are signed with.")
+	cmd.Flags().String("jwt-issuer", "", "Issuer RPC clients' JWTs must have, not checked when empty.")
+	cmd.Flags().String("jwt-audience", "", "Audience RPC clients' JWTs must have, not checked when empty.")
+	cmd.Flags().String("jwt-subject-claim", "sub", "Claim of RPC clients' JWTs holding their subject.")
+	cmd.Flags().String("api-keys-file", "", "Path to the subject:sha256-hex file of RPC clients' API keys.")
+	cmd.Flags().Bool("token-only-clients", false, "Let RPC clients with tokens connect without client certificates.")
 
 	cmd.Flags().String("acl-model-file", "", "Path to ACL model.")
 	cmd.Flags().String("acl-policy-file", "", "Path to ACL policy.")
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/raft"
+	"github.com/madalosso/proglog/internal/log"
+	"github.com/spf13/cobra"
+)
+
+func newRecoverCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "recover <id>=<rpc-addr>...",
+		Short: "Recover from a lost quorum by rewriting the raft configuration.",
+		Long: "Recover from a lost quorum by rewriting the raft configuration " +
+			"to the surviving servers. Stop every survivor, run recover on each " +
+			"of them with the same servers, then start them again.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: runRecover,
+	}
+	// only used to validate the raft config, the id isn't written anywhere
+	hostname, _ := os.Hostname()
+	dataDir := path.Join(os.TempDir(), "proglog")
+	cmd.Flags().String("data-dir", dataDir, "Directory to store log and Raft data.")
+	cmd.Flags().String("node-name", hostname, "Unique server ID.")
+	return cmd
+}
+
+func runRecover(cmd *cobra.Command, args []string) error {
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		return err
+	}
+	nodeName, err := cmd.Flags().GetString("node-name")
+	if err != nil {
+		return err
+	}
+
+	var servers []raft.Server
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return fmt.Errorf("invalid server %q, want <id>=<rpc-addr>", arg)
+		}
+		servers = append(servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(kv[0]),
+			Address:  raft.ServerAddress(kv[1]),
+		})
+	}
+
+	config := log.Config{}
+	config.Raft.LocalID = raft.ServerID(nodeName)
+	if err := log.RecoverCluster(dataDir, config, servers); err != nil {
+		return err
+	}
+	fmt.Printf("recovered %s with %d servers\n", dataDir, len(servers))
+	return nil
+}
@@ -6,18 +6,24 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/serf"
+	api "github.com/madalosso/proglog/api/v1"
 	"github.com/madalosso/proglog/internal/auth"
 	"github.com/madalosso/proglog/internal/discovery"
+	"github.com/madalosso/proglog/internal/kafka"
 	"github.com/madalosso/proglog/internal/log"
+	"github.com/madalosso/proglog/internal/resp"
 	"github.com/madalosso/proglog/internal/server"
 	"github.com/soheilhy/cmux"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
 type Agent struct {
@@ -26,7 +32,12 @@ type Agent struct {
 	mux        cmux.CMux
 	log        *log.DistributedLog
 	server     *grpc.Server
+	httpServer *http.Server
+	kafka      *kafka.Server
+	resp       *resp.Server
 	membership *discovery.Membership
+	// membershipReady is closed once membership is set up.
+	membershipReady chan struct{}
 
 	// replicator log.Replicator
 	shutdown     bool
@@ -45,6 +56,87 @@ type Config struct {
 	ACLModelFile    string
 	ACLPolicyFile   string
 	Bootstrap       bool
+	// BootstrapExpect bootstraps the cluster once this many servers are
+	// visible, instead of relying on a single Bootstrap node.
+	BootstrapExpect int
+	// Nonvoter joins the node as a read replica that doesn't take part in
+	// elections or commit quorums.
+	Nonvoter bool
+
+	// Raft tuning, zero values fall back to the defaults.
+	RaftHeartbeatTimeout   time.Duration
+	RaftElectionTimeout    time.Duration
+	RaftLeaderLeaseTimeout time.Duration
+	RaftCommitTimeout      time.Duration
+	RaftTransportMaxPool   int
+	RaftTransportTimeout   time.Duration
+	RaftApplyTimeout       time.Duration
+	// RaftMaxAppendEntries caps the entries raft sends or applies at once,
+	// RaftBatchApply lets raft commit that many applies at once.
+	RaftMaxAppendEntries int
+	RaftBatchApply       bool
+	// RaftAppendBatchSize caps how many concurrent appends share a raft
+	// entry.
+	RaftAppendBatchSize int
+
+	// Autopilot lets the leader promote servers once they're stable and
+	// remove the ones that have been dead for too long.
+	Autopilot                    bool
+	AutopilotDeadServerThreshold time.Duration
+	AutopilotStabilizationTime   time.Duration
+	AutopilotMaxVoters           int
+
+	// ReconcileInterval is how often the leader makes the raft configuration
+	// match serf's members, every minute when 0.
+	ReconcileInterval time.Duration
+
+	// EncryptKey is the base64 encoded key encrypting gossip between the
+	// servers. It's only read on first start, after that the servers use the
+	// keyring kept in the data dir.
+	EncryptKey string
+
+	// Zone is the zone, or rack, the server runs in. Clients prefer to read
+	// from servers in their zone.
+	Zone string
+
+	// MaxConsumeWait caps how long consumers can wait for records yet to be
+	// appended, 30s when 0.
+	MaxConsumeWait time.Duration
+
+	// Topic is the name the log goes by, in ACLs as topics/<topic>.
+	// "proglog" when empty.
+	Topic string
+
+	// KafkaPort serves the log over the Kafka protocol, as the KafkaTopic
+	// topic, on the bind address' host. It's off when 0. KafkaTopic is
+	// Topic when empty, Kafka clients' ACLs name the log by it.
+	KafkaPort  int
+	KafkaTopic string
+
+	// RESPPort serves the log as the RESPStream Redis stream on the bind
+	// address' host. It's off when 0. RESPStream is Topic when empty, Redis
+	// clients' ACLs name the log by it. Clients without certificates AUTH
+	// with the passwords in RESPPasswordFile.
+	RESPPort         int
+	RESPStream       string
+	RESPPasswordFile string
+
+	// CertSubject is the field of the client certificates clients
+	// authenticate as, "cn", "uri", "dns" or "email", their common name when
+	// empty. The Kafka and Redis clients authenticate by it too.
+	CertSubject string
+	// JWKSFile lets RPC clients authenticate with bearer JWTs signed by its
+	// keys, issued by JWTIssuer for JWTAudience when set, as the
+	// JWTSubjectClaim claim. APIKeysFile lets them authenticate with the API
+	// keys it holds the hashes of. Clients still need certificates when the
+	// server requires them, unless TokenOnlyClients lets those with tokens
+	// go without, like the ones behind TLS-terminating proxies.
+	JWKSFile         string
+	JWTIssuer        string
+	JWTAudience      string
+	JWTSubjectClaim  string
+	APIKeysFile      string
+	TokenOnlyClients bool
 }
 
 func (c Config) RPCAddr() (string, error) {
@@ -55,18 +147,49 @@ func (c Config) RPCAddr() (string, error) {
 	return fmt.Sprintf("%s:%d", host, c.RPCPort), nil
 }
 
+func (c Config) KafkaAddr() (string, error) {
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, c.KafkaPort), nil
+}
+
+func (c Config) RESPAddr() (string, error) {
+	host, _, err := net.SplitHostPort(c.BindAddr)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d", host, c.RESPPort), nil
+}
+
 func New(config Config) (*Agent, error) {
+	if config.Bootstrap && config.Nonvoter {
+		return nil, fmt.Errorf("non-voter can't bootstrap the cluster")
+	}
+	if config.BootstrapExpect < 0 {
+		return nil, fmt.Errorf("bootstrap expect can't be negative")
+	}
+	if config.BootstrapExpect > 0 && (config.Bootstrap || config.Nonvoter) {
+		return nil, fmt.Errorf(
+			"bootstrap expect can't be combined with bootstrap or non-voter",
+		)
+	}
 	a := &Agent{
 		Config:    config,
 		shutdowns: make(chan struct{}),
+
+		membershipReady: make(chan struct{}),
 	}
 
 	setup := []func() error{
 		a.setupLogger,
 		a.setupMux,
 		a.setupLog,
-		a.setupServer,
 		a.setupMembership,
+		a.setupServer,
+		a.setupKafka,
+		a.setupRESP,
 	}
 	for _, fn := range setup {
 		if err := fn(); err != nil {
@@ -74,6 +197,10 @@ func New(config Config) (*Agent, error) {
 		}
 	}
 	go a.serve()
+	go a.reconcile()
+	if a.Config.BootstrapExpect > 0 {
+		go a.bootstrapExpect()
+	}
 	return a, nil
 }
 
@@ -124,6 +251,21 @@ func (a *Agent) setupLog() error {
 
 	logConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
 	logConfig.Raft.Bootstrap = a.Config.Bootstrap
+	logConfig.Raft.HeartbeatTimeout = a.Config.RaftHeartbeatTimeout
+	logConfig.Raft.ElectionTimeout = a.Config.RaftElectionTimeout
+	logConfig.Raft.LeaderLeaseTimeout = a.Config.RaftLeaderLeaseTimeout
+	logConfig.Raft.CommitTimeout = a.Config.RaftCommitTimeout
+	logConfig.Raft.TransportMaxPool = a.Config.RaftTransportMaxPool
+	logConfig.Raft.TransportTimeout = a.Config.RaftTransportTimeout
+	logConfig.Raft.ApplyTimeout = a.Config.RaftApplyTimeout
+	logConfig.Raft.MaxAppendEntries = a.Config.RaftMaxAppendEntries
+	logConfig.Raft.BatchApplyCh = a.Config.RaftBatchApply
+	logConfig.Raft.AppendBatchSize = a.Config.RaftAppendBatchSize
+	logConfig.Autopilot.Enabled = a.Config.Autopilot
+	logConfig.Autopilot.DeadServerThreshold = a.Config.AutopilotDeadServerThreshold
+	logConfig.Autopilot.StabilizationTime = a.Config.AutopilotStabilizationTime
+	logConfig.Autopilot.MaxVoters = a.Config.AutopilotMaxVoters
+	logConfig.Autopilot.Candidate = a.wantsToVote
 
 	a.log, err = log.NewDistributedLog(
 		a.Config.DataDir,
@@ -139,37 +281,287 @@ func (a *Agent) setupLog() error {
 	return err
 }
 
+// The JSON API's timeouts keep slow clients from holding connections open.
+// Streaming consumes lift the read timeout once the request is read.
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = 30 * time.Second
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+// grpcStopTimeout bounds how long shutting down waits for the RPCs in
+// flight, consumes waiting for records would hold it up for their MaxWaitMs.
+const grpcStopTimeout = 5 * time.Second
+
 func (a *Agent) setupServer() error {
 	authorizer := auth.New(
 		a.Config.ACLModelFile,
 		a.Config.ACLPolicyFile,
 	)
 	serverConfig := &server.Config{
-		CommitLog:   a.log,
-		Authorizer:  authorizer,
-		GetServerer: a.log,
+		CommitLog:    a.log,
+		Authorizer:   authorizer,
+		GetServerer:  &memberServers{log: a.log, membership: a.membership},
+		ClusterAdmin: a.log,
+		Keyring:      a.membership,
+		Topic:        a.Config.Topic,
+
+		MaxConsumeWait: a.Config.MaxConsumeWait,
+	}
+	var err error
+	serverConfig.Authenticators, err = a.authenticators()
+	if err != nil {
+		return err
 	}
 
+	// the JSON API is served on the same port, over the same TLS
+	ln := a.mux.Match(cmux.Any())
 	var opts []grpc.ServerOption
+	var grpcLn, httpLn net.Listener
+	var serve func() error
 	if a.Config.ServerTLSConfig != nil {
-		creds := credentials.NewTLS(a.Config.ServerTLSConfig)
-		opts = append(opts, grpc.Creds(creds))
+		tlsConfig := a.Config.ServerTLSConfig.Clone()
+		tokens := a.Config.JWKSFile != "" || a.Config.APIKeysFile != ""
+		if tokens && a.Config.TokenOnlyClients &&
+			tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+			// clients without certificates are authenticated by their tokens
+			// or denied
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		splitter := newALPNSplitter(tls.NewListener(ln, tlsConfig))
+		grpcLn, httpLn, serve = splitter.grpc, splitter.http, splitter.Serve
+		opts = append(opts, grpc.Creds(newHandshakenTLS()))
+	} else {
+		serverMux := cmux.New(ln)
+		httpLn = serverMux.Match(cmux.HTTP1Fast())
+		grpcLn = serverMux.Match(cmux.Any())
+		serve = serverMux.Serve
 	}
 
-	var err error
 	a.server, err = server.NewGRPCServer(serverConfig, opts...)
 	if err != nil {
 		return err
 	}
+	handler, err := server.NewHTTPHandler(serverConfig)
+	if err != nil {
+		return err
+	}
+	a.httpServer = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
 
-	grpcLn := a.mux.Match(cmux.Any())
 	go func() {
 		if err := a.server.Serve(grpcLn); err != nil {
 			_ = a.Shutdown()
 		}
 	}()
+	go func() {
+		if err := a.httpServer.Serve(httpLn); err != http.ErrServerClosed {
+			_ = a.Shutdown()
+		}
+	}()
+	go func() {
+		_ = serve()
+	}()
 
-	return err
+	return nil
+}
+
+// authenticators authenticate RPC clients with the tokens they're configured
+// with, then with their certificates.
+func (a *Agent) authenticators() ([]auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if a.Config.JWKSFile != "" {
+		jwtAuthenticator, err := server.NewJWTAuthenticator(server.JWTConfig{
+			JWKSFile:     a.Config.JWKSFile,
+			Issuer:       a.Config.JWTIssuer,
+			Audience:     a.Config.JWTAudience,
+			SubjectClaim: a.Config.JWTSubjectClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+	if a.Config.APIKeysFile != "" {
+		apiKeys, err := server.LoadAPIKeys(a.Config.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, apiKeys)
+	}
+	certAuthenticator, err := a.certAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+	return append(authenticators, certAuthenticator), nil
+}
+
+// certAuthenticator authenticates the clients of every protocol by the same
+// field of their certificates.
+func (a *Agent) certAuthenticator() (auth.CertAuthenticator, error) {
+	return auth.NewCertAuthenticator(auth.CertField(a.Config.CertSubject))
+}
+
+func (a *Agent) setupKafka() error {
+	if a.Config.KafkaPort == 0 {
+		return nil
+	}
+	addr, err := a.Config.KafkaAddr()
+	if err != nil {
+		return err
+	}
+	certAuthenticator, err := a.certAuthenticator()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if a.Config.ServerTLSConfig != nil {
+		ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+	}
+	a.kafka, err = kafka.NewServer(kafka.Config{
+		Log:               a.log,
+		Authorizer:        auth.New(a.Config.ACLModelFile, a.Config.ACLPolicyFile),
+		CertAuthenticator: certAuthenticator,
+		Cluster: &kafkaBrokers{
+			servers:    &memberServers{log: a.log, membership: a.membership},
+			membership: a.membership,
+		},
+		Topic:        firstNonEmpty(a.Config.KafkaTopic, a.Config.Topic),
+		MaxFetchWait: a.Config.MaxConsumeWait,
+	})
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+	go func() {
+		if err := a.kafka.Serve(ln); err != nil {
+			_ = a.Shutdown()
+		}
+	}()
+	return nil
+}
+
+func (a *Agent) setupRESP() error {
+	if a.Config.RESPPort == 0 {
+		return nil
+	}
+	certAuthenticator, err := a.certAuthenticator()
+	if err != nil {
+		return err
+	}
+	respConfig := resp.Config{
+		Log:               a.log,
+		Authorizer:        auth.New(a.Config.ACLModelFile, a.Config.ACLPolicyFile),
+		CertAuthenticator: certAuthenticator,
+		Stream:            firstNonEmpty(a.Config.RESPStream, a.Config.Topic),
+		MaxBlock:          a.Config.MaxConsumeWait,
+	}
+	if a.Config.RESPPasswordFile != "" {
+		passwords, err := auth.LoadPasswords(a.Config.RESPPasswordFile)
+		if err != nil {
+			return err
+		}
+		respConfig.Authenticator = passwords
+	}
+	addr, err := a.Config.RESPAddr()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if a.Config.ServerTLSConfig != nil {
+		ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+	}
+	a.resp, err = resp.NewServer(respConfig)
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+	go func() {
+		if err := a.resp.Serve(ln); err != nil {
+			_ = a.Shutdown()
+		}
+	}()
+	return nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// kafkaBrokers are the servers that publish a Kafka address through serf.
+type kafkaBrokers struct {
+	servers    *memberServers
+	membership *discovery.Membership
+}
+
+func (b *kafkaBrokers) Brokers() ([]kafka.Broker, error) {
+	servers, err := b.servers.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make(map[string]string)
+	for _, member := range b.membership.Members() {
+		addrs[member.Name] = member.Tags["kafka_addr"]
+	}
+	var brokers []kafka.Broker
+	for _, server := range servers {
+		if addrs[server.Id] == "" {
+			continue
+		}
+		brokers = append(brokers, kafka.Broker{
+			ID:     server.Id,
+			Addr:   addrs[server.Id],
+			Rack:   server.Zone,
+			Leader: server.IsLeader,
+		})
+	}
+	return brokers, nil
+}
+
+// memberServers adds what the servers publish through serf, their zones and
+// network coordinates, to raft's servers.
+type memberServers struct {
+	log        *log.DistributedLog
+	membership *discovery.Membership
+}
+
+func (s *memberServers) GetServers() ([]*api.Server, error) {
+	servers, err := s.log.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	zones := make(map[string]string)
+	for _, member := range s.membership.Members() {
+		zones[member.Name] = member.Tags["zone"]
+	}
+	for _, server := range servers {
+		server.Zone = zones[server.Id]
+		if coord, ok := s.membership.Coordinate(server.Id); ok {
+			server.Coordinate = &api.Coordinate{
+				Vec:        coord.Vec,
+				Error:      coord.Error,
+				Adjustment: coord.Adjustment,
+				Height:     coord.Height,
+			}
+		}
+	}
+	return servers, nil
 }
 
 func (a *Agent) setupMembership() error {
@@ -178,15 +570,60 @@ func (a *Agent) setupMembership() error {
 		return err
 	}
 
+	role := api.RoleVoter
+	if a.Config.Nonvoter {
+		role = api.RoleNonvoter
+	}
+
+	tags := map[string]string{
+		"rpc_addr": rpcAddr,
+		"role":     role,
+	}
+	if a.Config.Zone != "" {
+		tags["zone"] = a.Config.Zone
+	}
+	if a.Config.KafkaPort != 0 {
+		kafkaAddr, err := a.Config.KafkaAddr()
+		if err != nil {
+			return err
+		}
+		tags["kafka_addr"] = kafkaAddr
+	}
+	if a.Config.BootstrapExpect > 0 {
+		tags["bootstrap_expect"] = strconv.Itoa(a.Config.BootstrapExpect)
+	}
+
 	a.membership, err = discovery.New(a.log, discovery.Config{
-		NodeName: a.Config.NodeName,
-		BindAddr: a.Config.BindAddr,
-		Tags: map[string]string{
-			"rpc_addr": rpcAddr,
-		},
+		NodeName:       a.Config.NodeName,
+		BindAddr:       a.Config.BindAddr,
+		Tags:           tags,
 		StartJoinAddrs: a.Config.StartJoinAddrs,
+		EncryptKey:     a.Config.EncryptKey,
+		KeyringFile:    filepath.Join(a.Config.DataDir, "serf", "keyring"),
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	close(a.membershipReady)
+	return nil
+}
+
+// wantsToVote tells a new leader's autopilot whether the non-voter is a
+// member that joined as a voter. Until serf is set up it knows of none, and
+// reconcile joins them again once it is.
+func (a *Agent) wantsToVote(id raft.ServerID) bool {
+	select {
+	case <-a.membershipReady:
+	default:
+		return false
+	}
+	for _, member := range a.membership.Members() {
+		if member.Name == string(id) {
+			return member.Status == serf.StatusAlive &&
+				member.Tags["role"] != api.RoleNonvoter
+		}
+	}
+	return false
 }
 
 func (a *Agent) Shutdown() error {
@@ -201,10 +638,23 @@ func (a *Agent) Shutdown() error {
 	close(a.shutdowns)
 
 	shutdown := []func() error{
+		a.transferLeadership,
 		a.membership.Leave,
+		// consume streams wouldn't let a graceful shutdown finish, and it
+		// goes first as the servers' listeners share the one they wrap
+		a.httpServer.Close,
+		a.stopServer,
+		func() error {
+			if a.kafka == nil {
+				return nil
+			}
+			return a.kafka.Close()
+		},
 		func() error {
-			a.server.GracefulStop()
-			return nil
+			if a.resp == nil {
+				return nil
+			}
+			return a.resp.Close()
 		},
 		a.log.Close,
 	}
@@ -216,6 +666,38 @@ func (a *Agent) Shutdown() error {
 	return nil
 }
 
+// stopServer stops the gRPC server gracefully, cutting the RPCs still
+// running after grpcStopTimeout short.
+func (a *Agent) stopServer() error {
+	stopped := make(chan struct{})
+	go func() {
+		a.server.GracefulStop()
+		close(stopped)
+	}()
+	timer := time.NewTimer(grpcStopTimeout)
+	defer timer.Stop()
+	select {
+	case <-stopped:
+	case <-timer.C:
+		a.server.Stop()
+		<-stopped
+	}
+	return nil
+}
+
+// transferLeadership hands leadership to another voter before shutting down so
+// the cluster doesn't wait out an election timeout to accept writes again.
+func (a *Agent) transferLeadership() error {
+	err := a.log.TransferLeadership("")
+	if err != nil && err != raft.ErrNotLeader {
+		zap.L().Named("agent").Warn(
+			"failed to transfer leadership",
+			zap.Error(err),
+		)
+	}
+	return nil
+}
+
 func (a *Agent) serve() error {
 	if err := a.mux.Serve(); err != nil {
 		return err
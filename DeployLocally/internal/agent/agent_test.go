@@ -4,23 +4,249 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	api "github.com/madalosso/proglog/api/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 
 	"github.com/madalosso/proglog/internal/agent"
 	"github.com/madalosso/proglog/internal/config"
 	"github.com/madalosso/proglog/internal/loadbalance"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
+	"github.com/twmb/franz-go/pkg/kgo"
 )
 
 func TestAgent(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 3, func(i int, c *agent.Config) {
+		c.Bootstrap = i == 0
+		c.Zone = fmt.Sprintf("zone-%d", i)
+	})
+
+	// wait until agents have joined the cluster
+	time.Sleep(3 * time.Second)
+
+	leaderClient := client(t, agents[0], peerTLSConfig)
+	// the picker only routes produce and consume calls
+	serversResponse, err := api.NewLogClient(dial(t, agents[0], peerTLSConfig)).GetServers(
+		context.Background(),
+		&api.GetServersRequest{},
+	)
+	require.NoError(t, err)
+	for _, server := range serversResponse.Servers {
+		require.Equal(t, "zone-"+server.Id, server.Zone)
+	}
+	msg := []byte("foo")
+	produceResponse, err := leaderClient.Produce(
+		context.Background(),
+		&api.ProduceRequest{
+			Record: &api.Record{
+				Value: msg,
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	// wait until replication has finished
+	time.Sleep(3 * time.Second)
+
+	consumeResponse, err := leaderClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{
+			Offset: produceResponse.Offset,
+		})
+	require.NoError(t, err)
+	require.Equal(t, consumeResponse.Record.Value, msg)
+
+	time.Sleep(3 * time.Second)
+
+	followerClient := client(t, agents[1], peerTLSConfig)
+	consumeResponse, err = followerClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{
+			Offset: produceResponse.Offset,
+		})
+	require.NoError(t, err)
+	require.Equal(t, consumeResponse.Record.Value, msg)
+
+	consumeResponse, err = leaderClient.Consume(
+		context.Background(),
+		&api.ConsumeRequest{
+			Offset: produceResponse.Offset + 1,
+		},
+	)
+	require.Nil(t, consumeResponse)
+	require.Error(t, err)
+	got := status.Code(err)
+	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
+	require.Equal(t, want, got)
+}
+
+func TestAgentBootstrapExpect(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 3, func(i int, c *agent.Config) {
+		c.BootstrapExpect = 3
+	})
+
+	// the lowest named agent bootstraps once all three have joined
+	var servers []*api.Server
+	lastClient := api.NewLogClient(dial(t, agents[2], peerTLSConfig))
+	// calls are bounded so none outlives the Eventually checking them
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		res, err := lastClient.GetServers(
+			ctx,
+			&api.GetServersRequest{},
+		)
+		if err != nil {
+			return false
+		}
+		servers = res.Servers
+		return len(servers) == 3
+	}, 10*time.Second, 250*time.Millisecond)
+	require.Equal(t, "0", servers[0].Id)
+
+	// whichever agent got elected takes the record
+	var clients []api.LogClient
+	for _, agent := range agents {
+		clients = append(clients, api.NewLogClient(dial(t, agent, peerTLSConfig)))
+	}
+	require.Eventually(t, func() bool {
+		for _, client := range clients {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			_, err := client.Produce(
+				ctx,
+				&api.ProduceRequest{Record: &api.Record{Value: []byte("foo")}},
+			)
+			cancel()
+			if err == nil {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 250*time.Millisecond)
+}
+
+func TestAgentReconcile(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 3, func(i int, c *agent.Config) {
+		c.Bootstrap = i == 0
+		c.ReconcileInterval = 200 * time.Millisecond
+	})
+
+	logClient := api.NewLogClient(dial(t, agents[0], peerTLSConfig))
+	serverCount := func() int {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		res, err := logClient.GetServers(
+			ctx,
+			&api.GetServersRequest{},
+		)
+		if err != nil {
+			return 0
+		}
+		return len(res.Servers)
+	}
+	require.Eventually(t, func() bool {
+		return serverCount() == 3
+	}, 10*time.Second, 250*time.Millisecond)
+
+	// serf still sees the removed server so the leader adds it back
+	adminClient := api.NewAdminClient(dial(t, agents[0], peerTLSConfig))
+	_, err := adminClient.RemoveServer(
+		context.Background(),
+		&api.RemoveServerRequest{Id: "2"},
+	)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return serverCount() == 3
+	}, 5*time.Second, 250*time.Millisecond)
+
+	// serf never knew of the server added by hand so the leader keeps it
+	_, err = adminClient.AddVoter(
+		context.Background(),
+		&api.AddVoterRequest{Id: "outside", RpcAddr: "127.0.0.1:1"},
+	)
+	require.NoError(t, err)
+	require.Equal(t, 4, serverCount())
+	time.Sleep(5 * 200 * time.Millisecond)
+	require.Equal(t, 4, serverCount())
+}
+
+func TestAgentPromoteAfterLeaderChange(t *testing.T) {
+	// only the new leader reconciles within the test, as it takes over
+	agents, peerTLSConfig := setupAgents(t, 3, func(i int, c *agent.Config) {
+		c.Bootstrap = i == 0
+		c.ReconcileInterval = time.Hour
+	})
+
+	adminClients := make(map[string]api.AdminClient)
+	for _, a := range agents {
+		adminClients[a.Config.NodeName] = api.NewAdminClient(dial(t, a, peerTLSConfig))
+	}
+	logClient := api.NewLogClient(dial(t, agents[0], peerTLSConfig))
+	servers := func() (leader string, roles map[string]string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		res, err := logClient.GetServers(ctx, &api.GetServersRequest{})
+		if err != nil {
+			return "", nil
+		}
+		roles = make(map[string]string)
+		for _, server := range res.Servers {
+			roles[server.Id] = server.Role
+			if server.IsLeader {
+				leader = server.Id
+			}
+		}
+		return leader, roles
+	}
+	var leader string
+	require.Eventually(t, func() bool {
+		var roles map[string]string
+		leader, roles = servers()
+		return leader != "" && len(roles) == 3 &&
+			roles["0"] == api.RoleVoter &&
+			roles["1"] == api.RoleVoter &&
+			roles["2"] == api.RoleVoter
+	}, 10*time.Second, 250*time.Millisecond)
+
+	// serf still tags the demoted server as a voter, which the next leader
+	// goes by once it leads
+	var others []string
+	for _, a := range agents {
+		if a.Config.NodeName != leader {
+			others = append(others, a.Config.NodeName)
+		}
+	}
+	next, demoted := others[0], others[1]
+	ctx := context.Background()
+	_, err := adminClients[leader].DemoteVoter(ctx, &api.DemoteVoterRequest{Id: demoted})
+	require.NoError(t, err)
+	_, err = adminClients[leader].TransferLeadership(
+		ctx,
+		&api.TransferLeadershipRequest{Id: next},
+	)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		leader, roles := servers()
+		return leader == next && roles[demoted] == api.RoleVoter
+	}, 10*time.Second, 250*time.Millisecond)
+}
+
+func setupAgents(t *testing.T, count int, fn func(int, *agent.Config)) ([]*agent.Agent, *tls.Config) {
+	t.Helper()
+
 	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
 		CertFile:      config.ServerCertFile,
 		KeyFile:       config.ServerKeyFile,
@@ -40,7 +266,7 @@ func TestAgent(t *testing.T) {
 	require.NoError(t, err)
 
 	var agents []*agent.Agent
-	for i := 0; i < 3; i++ {
+	for i := 0; i < count; i++ {
 		ports := dynaport.Get(2)
 		bindAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
 		rpcPort := ports[1]
@@ -52,7 +278,7 @@ func TestAgent(t *testing.T) {
 			startJoinAddrs = append(startJoinAddrs, agents[0].Config.BindAddr)
 		}
 
-		agent, err := agent.New(agent.Config{
+		c := agent.Config{
 			NodeName:        fmt.Sprintf("%d", i),
 			StartJoinAddrs:  startJoinAddrs,
 			BindAddr:        bindAddr,
@@ -62,80 +288,221 @@ func TestAgent(t *testing.T) {
 			ACLPolicyFile:   config.ACLPolicyFile,
 			ServerTLSConfig: serverTLSConfig,
 			PeerTLSConfig:   peerTLSConfig,
-			Bootstrap:       i == 0,
-		})
+		}
+		if fn != nil {
+			fn(i, &c)
+		}
+		agent, err := agent.New(c)
 		require.NoError(t, err)
 		agents = append(agents, agent)
 	}
-	defer func() {
+	t.Cleanup(func() {
 		for _, agent := range agents {
 			err := agent.Shutdown()
 			require.NoError(t, err)
 			require.NoError(t, os.RemoveAll(agent.Config.DataDir))
 		}
-	}()
+	})
+	return agents, peerTLSConfig
+}
 
-	// wait until agents have joined the cluster
-	time.Sleep(3 * time.Second)
+func client(t *testing.T, agent *agent.Agent, tlsConfig *tls.Config) api.LogClient {
+	tlsCreds := credentials.NewTLS(tlsConfig)
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
+	rpcAddr, err := agent.Config.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.Dial(fmt.Sprintf(
+		"%s:///%s",
+		loadbalance.Name,
+		rpcAddr,
+	), opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	client := api.NewLogClient(conn)
+	return client
+}
 
-	leaderClient := client(t, agents[0], peerTLSConfig)
-	msg := []byte("foo")
-	produceResponse, err := leaderClient.Produce(
-		context.Background(),
-		&api.ProduceRequest{
-			Record: &api.Record{
-				Value: msg,
-			},
-		},
-	)
+func dial(t *testing.T, agent *agent.Agent, tlsConfig *tls.Config) *grpc.ClientConn {
+	tlsCreds := credentials.NewTLS(tlsConfig)
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
+	rpcAddr, err := agent.Config.RPCAddr()
+	require.NoError(t, err)
+	conn, err := grpc.Dial(rpcAddr, opts...)
 	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
 
-	// wait until replication has finished
+func TestAgentShutdownWhileConsuming(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 1, func(i int, c *agent.Config) {
+		c.Bootstrap = true
+	})
 	time.Sleep(3 * time.Second)
 
-	consumeResponse, err := leaderClient.Consume(
+	// waits for a record that's never produced
+	consumed := make(chan error, 1)
+	go func() {
+		_, err := api.NewLogClient(dial(t, agents[0], peerTLSConfig)).Consume(
+			context.Background(),
+			&api.ConsumeRequest{Offset: 0, MaxWaitMs: 60_000},
+		)
+		consumed <- err
+	}()
+	time.Sleep(time.Second)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- agents[0].Shutdown()
+	}()
+	select {
+	case err := <-shutdown:
+		require.NoError(t, err)
+	case <-time.After(15 * time.Second):
+		t.Fatal("shutdown waited for the consume")
+	}
+	require.Error(t, <-consumed)
+}
+
+func TestAgentHTTP(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 1, func(i int, c *agent.Config) {
+		c.Bootstrap = true
+	})
+	rpcAddr, err := agents[0].Config.RPCAddr()
+	require.NoError(t, err)
+	// gRPC clients negotiate h2, which the JSON API doesn't serve
+	conn, err := tls.Dial("tcp", rpcAddr, withNextProtos(peerTLSConfig, "h2", "http/1.1"))
+	require.NoError(t, err)
+	require.Equal(t, "h2", conn.ConnectionState().NegotiatedProtocol)
+	conn.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: withNextProtos(peerTLSConfig, "http/1.1"),
+	}}
+
+	// the JSON API shares the port and the TLS config with gRPC
+	res, err := httpClient.Post(
+		fmt.Sprintf("https://%s/v1/records", rpcAddr),
+		"application/json",
+		strings.NewReader(`{"record": {"value": "Zm9v"}}`),
+	)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "http/1.1", res.TLS.NegotiatedProtocol)
+	res.Body.Close()
+
+	consume, err := client(t, agents[0], peerTLSConfig).Consume(
 		context.Background(),
-		&api.ConsumeRequest{
-			Offset: produceResponse.Offset,
-		})
+		&api.ConsumeRequest{Offset: 0},
+	)
 	require.NoError(t, err)
-	require.Equal(t, consumeResponse.Record.Value, msg)
+	require.Equal(t, []byte("foo"), consume.Record.Value)
 
-	time.Sleep(3 * time.Second)
+	res, err = httpClient.Get(fmt.Sprintf("https://%s/v1/servers", rpcAddr))
+	require.NoError(t, err)
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	servers := &api.GetServersResponse{}
+	require.NoError(t, protojson.Unmarshal(b, servers))
+	require.Equal(t, 1, len(servers.Servers))
+	require.True(t, servers.Servers[0].IsLeader)
+}
 
-	followerClient := client(t, agents[1], peerTLSConfig)
-	consumeResponse, err = followerClient.Consume(
+func withNextProtos(tlsConfig *tls.Config, protos ...string) *tls.Config {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = protos
+	return tlsConfig
+}
+
+func TestAgentKafka(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 1, func(i int, c *agent.Config) {
+		c.Bootstrap = true
+		c.KafkaPort = dynaport.Get(1)[0]
+	})
+	kafkaAddr, err := agents[0].Config.KafkaAddr()
+	require.NoError(t, err)
+	kafkaClient, err := kgo.NewClient(
+		kgo.SeedBrokers(kafkaAddr),
+		kgo.DialTLSConfig(peerTLSConfig),
+		kgo.DefaultProduceTopic("proglog"),
+		kgo.ProducerBatchCompression(kgo.NoCompression()),
+		kgo.RecordDeliveryTimeout(5*time.Second),
+	)
+	require.NoError(t, err)
+	defer kafkaClient.Close()
+
+	// the brokers the client learns about come from the servers' serf tags
+	err = kafkaClient.ProduceSync(
 		context.Background(),
-		&api.ConsumeRequest{
-			Offset: produceResponse.Offset,
-		})
+		&kgo.Record{Key: []byte("key"), Value: []byte("foo")},
+	).FirstErr()
 	require.NoError(t, err)
-	require.Equal(t, consumeResponse.Record.Value, msg)
 
-	consumeResponse, err = leaderClient.Consume(
+	consume, err := client(t, agents[0], peerTLSConfig).Consume(
 		context.Background(),
-		&api.ConsumeRequest{
-			Offset: produceResponse.Offset + 1,
-		},
+		&api.ConsumeRequest{Offset: 0},
 	)
-	require.Nil(t, consumeResponse)
-	require.Error(t, err)
-	got := status.Code(err)
-	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
-	require.Equal(t, want, got)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo"), consume.Record.Value)
+	require.Equal(t, []byte("key"), consume.Record.Key)
 }
 
-func client(t *testing.T, agent *agent.Agent, tlsConfig *tls.Config) api.LogClient {
-	tlsCreds := credentials.NewTLS(tlsConfig)
-	opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
-	rpcAddr, err := agent.Config.RPCAddr()
+func TestAgentRESP(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 1, func(i int, c *agent.Config) {
+		c.Bootstrap = true
+		c.RESPPort = dynaport.Get(1)[0]
+	})
+	respAddr, err := agents[0].Config.RESPAddr()
 	require.NoError(t, err)
-	conn, err := grpc.Dial(fmt.Sprintf(
-		"%s:///%s",
-		loadbalance.Name,
-		rpcAddr,
-	), opts...)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:      respAddr,
+		TLSConfig: peerTLSConfig,
+	})
+	defer redisClient.Close()
+
+	id, err := redisClient.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: "proglog",
+		Values: []string{"key", "foo"},
+	}).Result()
 	require.NoError(t, err)
-	client := api.NewLogClient(conn)
-	return client
+	require.Equal(t, "0-1", id)
+
+	consume, err := client(t, agents[0], peerTLSConfig).Consume(
+		context.Background(),
+		&api.ConsumeRequest{Offset: 0},
+	)
+	require.NoError(t, err)
+	require.Equal(t, "key", consume.Record.Headers[0].Key)
+	require.Equal(t, []byte("foo"), consume.Record.Headers[0].Value)
+}
+
+// TestAgentCertSubject checks every protocol authenticates clients by the
+// same field of their certificates, which the test ones lack URIs in.
+func TestAgentCertSubject(t *testing.T) {
+	agents, peerTLSConfig := setupAgents(t, 1, func(i int, c *agent.Config) {
+		c.Bootstrap = true
+		c.RESPPort = dynaport.Get(1)[0]
+		c.CertSubject = "uri"
+	})
+
+	_, err := api.NewLogClient(dial(t, agents[0], peerTLSConfig)).Produce(
+		context.Background(),
+		&api.ProduceRequest{Record: &api.Record{Value: []byte("foo")}},
+	)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	respAddr, err := agents[0].Config.RESPAddr()
+	require.NoError(t, err)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:       respAddr,
+		TLSConfig:  peerTLSConfig,
+		MaxRetries: -1,
+	})
+	defer redisClient.Close()
+	require.Error(t, redisClient.Ping(context.Background()).Err())
 }
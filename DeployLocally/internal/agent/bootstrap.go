@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	api "github.com/madalosso/proglog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// bootstrapExpect waits until BootstrapExpect servers are visible through
+// serf, then the server with the lowest name bootstraps raft with all of them.
+func (a *Agent) bootstrapExpect() {
+	logger := zap.L().Named("agent")
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.shutdowns:
+			return
+		case <-ticker.C:
+			done, err := a.tryBootstrap()
+			if err != nil {
+				logger.Warn("failed to bootstrap", zap.Error(err))
+			}
+			if done {
+				return
+			}
+		}
+	}
+}
+
+func (a *Agent) tryBootstrap() (bool, error) {
+	hasState, err := a.log.HasState()
+	if err != nil {
+		return false, err
+	}
+	if hasState {
+		// bootstrapped already, or replicating from an existing cluster
+		return true, nil
+	}
+
+	servers := a.expectedServers()
+	if len(servers) < a.Config.BootstrapExpect {
+		return false, nil
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Id < servers[j].Id
+	})
+	if servers[0].Id != a.Config.NodeName {
+		// wait for the lowest named server to bootstrap and replicate to us
+		return false, nil
+	}
+
+	// a server that lost its data must not split the cluster it was part of
+	for _, server := range servers[1:] {
+		hasPeers, err := a.hasPeers(server.RpcAddr)
+		if err != nil {
+			return false, err
+		}
+		if hasPeers {
+			return true, nil
+		}
+	}
+	if err := a.log.Bootstrap(servers); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// expectedServers returns the alive members expecting the same cluster size.
+func (a *Agent) expectedServers() []*api.Server {
+	expect := strconv.Itoa(a.Config.BootstrapExpect)
+	var servers []*api.Server
+	for _, member := range a.membership.Members() {
+		if member.Status != serf.StatusAlive ||
+			member.Tags["bootstrap_expect"] != expect {
+			continue
+		}
+		servers = append(servers, &api.Server{
+			Id:      member.Name,
+			RpcAddr: member.Tags["rpc_addr"],
+		})
+	}
+	return servers
+}
+
+// hasPeers asks the server at rpcAddr whether it knows of a raft cluster.
+func (a *Agent) hasPeers(rpcAddr string) (bool, error) {
+	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if a.Config.PeerTLSConfig != nil {
+		creds := credentials.NewTLS(a.Config.PeerTLSConfig)
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	}
+	conn, err := grpc.Dial(rpcAddr, opts...)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := api.NewLogClient(conn).GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get servers from %s: %w", rpcAddr, err)
+	}
+	return len(res.Servers) > 0, nil
+}
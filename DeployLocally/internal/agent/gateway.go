@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// handshakeTimeout bounds how long a client has to finish the TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// gRPC and the JSON API share the port and its TLS, so the agent terminates
+// TLS itself and hands each connection to the server of the protocol it
+// negotiated with ALPN: h2 to gRPC, anything else, like http/1.1, to the
+// JSON API.
+type alpnSplitter struct {
+	ln   net.Listener
+	grpc *connListener
+	http *connListener
+}
+
+// newALPNSplitter splits the connections of the TLS listener. Its config
+// must offer h2 and http/1.1 with NextProtos.
+func newALPNSplitter(ln net.Listener) *alpnSplitter {
+	return &alpnSplitter{
+		ln:   ln,
+		grpc: newConnListener(ln.Addr()),
+		http: newConnListener(ln.Addr()),
+	}
+}
+
+// Serve splits the connections until the TLS listener is closed.
+func (s *alpnSplitter) Serve() error {
+	defer s.grpc.Close()
+	defer s.http.Close()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.route(conn)
+	}
+}
+
+func (s *alpnSplitter) route(conn net.Conn) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		_ = conn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		s.grpc.put(conn)
+	} else {
+		s.http.put(conn)
+	}
+}
+
+// connListener is a listener for the connections it's handed.
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *connListener) put(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// handshakenTLS hands gRPC the client's certificates of the connections the
+// alpnSplitter already handshook, as if gRPC had done the handshake.
+type handshakenTLS struct {
+	info credentials.ProtocolInfo
+}
+
+func newHandshakenTLS() credentials.TransportCredentials {
+	return &handshakenTLS{info: credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+	}}
+}
+
+func (c *handshakenTLS) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil, fmt.Errorf("connection isn't TLS: %T", conn)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{
+		State: tlsConn.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{
+			SecurityLevel: credentials.PrivacyAndIntegrity,
+		},
+	}, nil
+}
+
+func (c *handshakenTLS) ClientHandshake(
+	context.Context,
+	string,
+	net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("handshaken TLS is only for servers")
+}
+
+func (c *handshakenTLS) Info() credentials.ProtocolInfo {
+	return c.info
+}
+
+func (c *handshakenTLS) Clone() credentials.TransportCredentials {
+	return &handshakenTLS{info: c.info}
+}
+
+func (c *handshakenTLS) OverrideServerName(name string) error {
+	c.info.ServerName = name
+	return nil
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/serf/serf"
+	api "github.com/madalosso/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// reconcile makes the raft configuration converge to serf's membership. The
+// membership events are only handled by the leader when they arrive, so the
+// new leader reconciles when it takes over and then every ReconcileInterval
+// to catch up with the events it missed.
+func (a *Agent) reconcile() {
+	logger := zap.L().Named("agent")
+	interval := a.Config.ReconcileInterval
+	if interval == 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	leaderCh := a.log.LeaderCh()
+	for {
+		select {
+		case <-a.shutdowns:
+			return
+		case isLeader := <-leaderCh:
+			if !isLeader {
+				continue
+			}
+		case <-ticker.C:
+			if !a.log.IsLeader() {
+				continue
+			}
+		}
+		if err := a.reconcileMembers(); err != nil {
+			logger.Warn("failed to reconcile members", zap.Error(err))
+		}
+	}
+}
+
+// reconcileMembers joins the alive members missing from raft or in it with
+// another role than their tag's, and removes the servers that left. Joining
+// a non-voter that wants to vote again makes it a candidate for this
+// leader's autopilot, as the one that joined it may have been another's.
+// Servers missing from serf are only removed once serf reaped them: servers
+// added with the admin API never were members, and a new leader may not
+// have heard of every member yet. It keeps going past the changes that
+// fail, for the others to converge.
+func (a *Agent) reconcileMembers() error {
+	servers, err := a.log.GetServers()
+	if err != nil {
+		return err
+	}
+	inRaft := make(map[string]bool, len(servers))
+	roles := make(map[string]string, len(servers))
+	for _, server := range servers {
+		inRaft[server.Id] = true
+		roles[server.Id] = server.Role
+	}
+
+	var errs []error
+	inSerf := make(map[string]bool)
+	for _, member := range a.membership.Members() {
+		inSerf[member.Name] = true
+		if member.Name == a.Config.NodeName {
+			continue
+		}
+		var err error
+		switch member.Status {
+		case serf.StatusAlive:
+			role := member.Tags["role"]
+			if role != api.RoleNonvoter {
+				role = api.RoleVoter
+			}
+			if !inRaft[member.Name] || roles[member.Name] != role {
+				err = a.log.Join(
+					member.Name,
+					member.Tags["rpc_addr"],
+					member.Tags["role"],
+				)
+			}
+		case serf.StatusLeft:
+			if inRaft[member.Name] {
+				err = a.log.Leave(member.Name)
+			}
+		case serf.StatusFailed:
+			if inRaft[member.Name] {
+				err = a.log.Fail(member.Name)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", member.Name, err))
+		}
+	}
+
+	for _, server := range servers {
+		if server.Id == a.Config.NodeName || inSerf[server.Id] ||
+			!a.membership.Reaped(server.Id) {
+			continue
+		}
+		if err := a.log.Leave(server.Id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server.Id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
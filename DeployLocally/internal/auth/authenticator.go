@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// Credentials are what a client presented to authenticate: the certificate
+// chains verified during the TLS handshake, if any, and the scheme and token
+// of its authorization header or metadata, like "Bearer" and the JWT.
+type Credentials struct {
+	VerifiedChains [][]*x509.Certificate
+	Scheme         string
+	Token          string
+}
+
+// Authenticator tells which subject the credentials authenticate, the
+// subject the Authorizer then authorizes. It returns ErrNoCredentials when
+// the credentials aren't of its kind, for the next authenticator to try.
+type Authenticator interface {
+	Authenticate(ctx context.Context, creds Credentials) (string, error)
+}
+
+// ErrNoCredentials is returned by the authenticators given credentials they
+// don't know.
+var ErrNoCredentials = errors.New("no credentials")
+
+// CertField is the field of the client's certificate a CertAuthenticator
+// takes the subject from.
+type CertField string
+
+const (
+	// CertCommonName is the certificate's subject common name.
+	CertCommonName CertField = "cn"
+	// CertURISAN is the certificate's first URI SAN, like a SPIFFE ID.
+	CertURISAN CertField = "uri"
+	// CertDNSSAN is the certificate's first DNS name SAN.
+	CertDNSSAN CertField = "dns"
+	// CertEmailSAN is the certificate's first email address SAN.
+	CertEmailSAN CertField = "email"
+)
+
+// CertAuthenticator authenticates clients by their certificates, as the
+// certificate's Field, its common name when empty.
+type CertAuthenticator struct {
+	Field CertField
+}
+
+var _ Authenticator = CertAuthenticator{}
+
+// NewCertAuthenticator checks the field is one certificates have.
+func NewCertAuthenticator(field CertField) (CertAuthenticator, error) {
+	switch field {
+	case "", CertCommonName, CertURISAN, CertDNSSAN, CertEmailSAN:
+		return CertAuthenticator{Field: field}, nil
+	default:
+		return CertAuthenticator{}, fmt.Errorf("unknown certificate field: %q", field)
+	}
+}
+
+func (a CertAuthenticator) Authenticate(_ context.Context, creds Credentials) (string, error) {
+	if len(creds.VerifiedChains) == 0 || len(creds.VerifiedChains[0]) == 0 {
+		return "", ErrNoCredentials
+	}
+	cert := creds.VerifiedChains[0][0]
+	var subject string
+	switch a.Field {
+	case "", CertCommonName:
+		subject = cert.Subject.CommonName
+	case CertURISAN:
+		if len(cert.URIs) > 0 {
+			subject = cert.URIs[0].String()
+		}
+	case CertDNSSAN:
+		if len(cert.DNSNames) > 0 {
+			subject = cert.DNSNames[0]
+		}
+	case CertEmailSAN:
+		if len(cert.EmailAddresses) > 0 {
+			subject = cert.EmailAddresses[0]
+		}
+	}
+	if subject == "" {
+		return "", fmt.Errorf("certificate has no %s", a.Field)
+	}
+	return subject, nil
+}
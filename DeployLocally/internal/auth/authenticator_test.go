@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertAuthenticator(t *testing.T) {
+	spiffe, err := url.Parse("spiffe://proglog/producer")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "root"},
+		URIs:           []*url.URL{spiffe},
+		DNSNames:       []string{"producer.proglog"},
+		EmailAddresses: []string{"producer@proglog"},
+	}
+	creds := Credentials{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	for field, want := range map[CertField]string{
+		"":             "root",
+		CertCommonName: "root",
+		CertURISAN:     "spiffe://proglog/producer",
+		CertDNSSAN:     "producer.proglog",
+		CertEmailSAN:   "producer@proglog",
+	} {
+		authenticator, err := NewCertAuthenticator(field)
+		require.NoError(t, err)
+		subject, err := authenticator.Authenticate(context.Background(), creds)
+		require.NoError(t, err)
+		require.Equal(t, want, subject)
+	}
+
+	_, err = NewCertAuthenticator("serial")
+	require.Error(t, err)
+
+	_, err = CertAuthenticator{}.Authenticate(context.Background(), Credentials{})
+	require.Equal(t, ErrNoCredentials, err)
+
+	bare := Credentials{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	_, err = CertAuthenticator{Field: CertURISAN}.Authenticate(context.Background(), bare)
+	require.Error(t, err)
+	require.NotEqual(t, ErrNoCredentials, err)
+}
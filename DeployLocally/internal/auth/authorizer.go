@@ -4,12 +4,27 @@ import (
 	"fmt"
 
 	"github.com/casbin/casbin"
+	fileadapter "github.com/casbin/casbin/persist/file-adapter"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// legacyMatcher authorizes with models that predate roles, topics and admin
+// endpoints. Their policies granted the * object, which keyMatch matches to
+// every object, and consume, which still lets subjects describe the log.
+const legacyMatcher = `r.sub == p.sub && keyMatch(r.obj, p.obj) && ` +
+	`(r.act == p.act || (r.act == "describe" && (p.act == "produce" || p.act == "consume")))`
+
+// New builds the authorizer from the model and policy files. A model without
+// a role definition is taken for the one shipped before roles, and its
+// policy is kept working with legacyMatcher.
 func New(model, policy string) *Authorizer {
-	enforcer := casbin.NewEnforcer(model, policy)
+	m := casbin.NewModel()
+	m.LoadModel(model)
+	if _, ok := m["g"]; !ok {
+		m.AddDef("m", "m", legacyMatcher)
+	}
+	enforcer := casbin.NewEnforcer(m, fileadapter.NewAdapter(policy))
 	return &Authorizer{enforcer: enforcer}
 }
 
@@ -0,0 +1,90 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizer(t *testing.T) {
+	policy := filepath.Join(t.TempDir(), "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(`p, admins, *, *
+p, team-a, topics/team-a.*, produce
+p, readers, topics/*, consume
+g, root, admins
+g, alice, team-a
+g, team-a, readers
+`), 0600))
+	authorizer := auth.New(config.ACLModelFile, policy)
+
+	for _, tc := range []struct {
+		subject, object, action string
+		allowed                 bool
+	}{
+		{"root", "topics/team-b.orders", "produce", true},
+		{"root", "admin/AddVoter", "admin", true},
+		{"alice", "topics/team-a.orders", "produce", true},
+		{"alice", "topics/team-b.orders", "produce", false},
+		// roles inherit the roles they're assigned
+		{"alice", "topics/team-b.orders", "consume", true},
+		// producing or consuming lets subjects describe
+		{"alice", "topics/team-b.orders", "describe", true},
+		{"alice", "admin/AddVoter", "admin", false},
+		{"nobody", "topics/team-a.orders", "consume", false},
+	} {
+		err := authorizer.Authorize(tc.subject, tc.object, tc.action)
+		if tc.allowed {
+			require.NoError(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		} else {
+			require.Error(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		}
+	}
+}
+
+func TestAuthorizerLegacyModel(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.conf")
+	require.NoError(t, os.WriteFile(model, []byte(`[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`), 0600))
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(policy, []byte(`p, root, *, produce
+p, root, *, consume
+p, nobody, *, consume
+`), 0600))
+	authorizer := auth.New(model, policy)
+
+	for _, tc := range []struct {
+		subject, object, action string
+		allowed                 bool
+	}{
+		// the * object policies granted now covers every object
+		{"root", "topics/proglog", "produce", true},
+		{"root", "topics/proglog", "consume", true},
+		{"root", "topics/proglog", "describe", true},
+		{"root", "admin/AddVoter", "admin", false},
+		{"nobody", "topics/proglog", "consume", true},
+		{"nobody", "topics/proglog", "produce", false},
+		{"alice", "topics/proglog", "consume", false},
+	} {
+		err := authorizer.Authorize(tc.subject, tc.object, tc.action)
+		if tc.allowed {
+			require.NoError(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		} else {
+			require.Error(t, err, "%s %s %s", tc.subject, tc.action, tc.object)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LoadPasswords reads the bcrypt hashed passwords of the subjects from an
+// htpasswd file, one "subject:hash" line per subject, as made by
+// `htpasswd -nB subject`.
+func LoadPasswords(file string) (*Passwords, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hashes := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		subject, hash, ok := strings.Cut(text, ":")
+		if !ok || subject == "" {
+			return nil, fmt.Errorf("%s:%d: expected subject:hash", file, line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		hashes[subject] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &Passwords{hashes: hashes}, nil
+}
+
+// Passwords authenticates subjects by their passwords, for the clients that
+// can't authenticate with certificates.
+type Passwords struct {
+	hashes map[string][]byte
+}
+
+func (p *Passwords) Authenticate(subject, password string) error {
+	hash, ok := p.hashes[subject]
+	if !ok {
+		return fmt.Errorf("unknown subject: %s", subject)
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password))
+}
@@ -0,0 +1,138 @@
+// Package connserver serves the connections of the protocols the log speaks
+// besides gRPC, like Kafka's and Redis'. It tracks the connections it
+// accepts, so closing the server closes them too.
+package connserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/madalosso/proglog/internal/auth"
+)
+
+// handshakeTimeout bounds how long a client has to finish the TLS handshake.
+const handshakeTimeout = 10 * time.Second
+
+// Server serves each connection accepted on its listeners with the handler,
+// in its own goroutine.
+type Server struct {
+	handler func(net.Conn)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// New returns a server serving the connections with the handler. The
+// server closes the connections once the handler returns.
+func New(handler func(net.Conn)) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		handler: handler,
+		ctx:     ctx,
+		cancel:  cancel,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Context is canceled once the server is closed, for the handlers to stop
+// waiting.
+func (s *Server) Context() context.Context {
+	return s.ctx
+}
+
+// Serve serves the connections accepted on the listener until the server is
+// closed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+	s.handler(conn)
+}
+
+// Close stops accepting connections and closes the open ones.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// Subject is who the client's certificate authenticates it as, like the RPC
+// clients'. Clients without one are the empty subject. Clients that don't
+// finish the TLS handshake within handshakeTimeout fail it.
+func Subject(conn net.Conn, certs auth.CertAuthenticator) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", nil
+	}
+	_ = tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return "", err
+	}
+	_ = tlsConn.SetDeadline(time.Time{})
+	subject, err := certs.Authenticate(context.Background(), auth.Credentials{
+		VerifiedChains: tlsConn.ConnectionState().VerifiedChains,
+	})
+	if errors.Is(err, auth.ErrNoCredentials) {
+		return "", nil
+	}
+	return subject, err
+}
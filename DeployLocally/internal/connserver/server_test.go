@@ -0,0 +1,38 @@
+package connserver_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/madalosso/proglog/internal/connserver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseClosesConns(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	accepted := make(chan struct{})
+	srv := connserver.New(func(conn net.Conn) {
+		close(accepted)
+		// blocks until the server closes the connection
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	served := make(chan error)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	<-accepted
+
+	require.NoError(t, srv.Close())
+	require.NoError(t, <-served)
+	require.Error(t, srv.Context().Err())
+	_, err = conn.Read(make([]byte, 1))
+	require.Equal(t, io.EOF, err)
+
+	require.Equal(t, net.ErrClosed, srv.Serve(ln))
+}
@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/hashicorp/serf/serf"
+	"go.uber.org/zap"
+)
+
+// setupKeyring turns on gossip encryption. The keyring file, once it exists,
+// takes precedence over the encrypt key since it has the keys installed
+// across the cluster since then. Serf rewrites it whenever the keys change.
+func (m *Membership) setupKeyring(config *serf.Config) error {
+	if m.KeyringFile == "" {
+		if m.EncryptKey == "" {
+			return nil
+		}
+		key, err := base64.StdEncoding.DecodeString(m.EncryptKey)
+		if err != nil {
+			return fmt.Errorf("failed to decode encrypt key: %w", err)
+		}
+		config.MemberlistConfig.SecretKey = key
+		return nil
+	}
+
+	_, err := os.Stat(m.KeyringFile)
+	switch {
+	case os.IsNotExist(err):
+		if m.EncryptKey == "" {
+			return nil
+		}
+		if err := writeKeyringFile(m.KeyringFile, m.EncryptKey); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case m.EncryptKey != "":
+		m.logger.Warn(
+			"ignoring encrypt key, using the keyring file",
+			zap.String("keyring_file", m.KeyringFile),
+		)
+	}
+
+	keyring, err := loadKeyringFile(m.KeyringFile)
+	if err != nil {
+		return err
+	}
+	config.MemberlistConfig.Keyring = keyring
+	config.KeyringFile = m.KeyringFile
+	return nil
+}
+
+func writeKeyringFile(path, key string) error {
+	if _, err := base64.StdEncoding.DecodeString(key); err != nil {
+		return fmt.Errorf("failed to decode encrypt key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal([]string{key})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+func loadKeyringFile(path string) (*memberlist.Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var encoded []string
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return nil, fmt.Errorf("failed to decode keyring file: %w", err)
+	}
+	if len(encoded) == 0 {
+		return nil, fmt.Errorf("keyring file %s has no keys", path)
+	}
+	keys := make([][]byte, len(encoded))
+	for i, key := range encoded {
+		keys[i], err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key from keyring: %w", err)
+		}
+	}
+	// serf writes the primary key first
+	return memberlist.NewKeyring(keys, keys[0])
+}
+
+// InstallKey adds the base64 encoded key to every member's keyring.
+func (m *Membership) InstallKey(key string) error {
+	if err := m.checkEncryption(); err != nil {
+		return err
+	}
+	return keyError(m.serf.KeyManager().InstallKey(key))
+}
+
+// UseKey makes the installed key the one every member encrypts with.
+func (m *Membership) UseKey(key string) error {
+	if err := m.checkEncryption(); err != nil {
+		return err
+	}
+	return keyError(m.serf.KeyManager().UseKey(key))
+}
+
+// RemoveKey removes the key from every member's keyring. The key in use
+// can't be removed.
+func (m *Membership) RemoveKey(key string) error {
+	if err := m.checkEncryption(); err != nil {
+		return err
+	}
+	return keyError(m.serf.KeyManager().RemoveKey(key))
+}
+
+// ListKeys returns how many members have each key installed, along with the
+// number of members.
+func (m *Membership) ListKeys() (map[string]int, int, error) {
+	if err := m.checkEncryption(); err != nil {
+		return nil, 0, err
+	}
+	res, err := m.serf.KeyManager().ListKeys()
+	if err := keyError(res, err); err != nil {
+		return nil, 0, err
+	}
+	return res.Keys, res.NumNodes, nil
+}
+
+func (m *Membership) checkEncryption() error {
+	if !m.serf.EncryptionEnabled() {
+		return fmt.Errorf("gossip encryption isn't enabled")
+	}
+	return nil
+}
+
+// keyError adds what the members that failed said to the error.
+func keyError(res *serf.KeyResponse, err error) error {
+	if err == nil {
+		return nil
+	}
+	if res == nil || len(res.Messages) == 0 {
+		return err
+	}
+	var msgs []string
+	for name, msg := range res.Messages {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", name, msg))
+	}
+	sort.Strings(msgs)
+	return fmt.Errorf("%w (%s)", err, strings.Join(msgs, ", "))
+}
@@ -2,8 +2,10 @@ package discovery
 
 import (
 	"net"
+	"sync"
 
 	"github.com/hashicorp/raft"
+	"github.com/hashicorp/serf/coordinate"
 	"github.com/hashicorp/serf/serf"
 	"go.uber.org/zap"
 )
@@ -14,6 +16,11 @@ type Membership struct {
 	serf    *serf.Serf
 	events  chan serf.Event
 	logger  *zap.Logger
+
+	mu sync.Mutex
+	// reaped are the members serf forgot after they left or failed, until
+	// they join again.
+	reaped map[string]bool
 }
 
 type Config struct {
@@ -21,11 +28,18 @@ type Config struct {
 	BindAddr       string
 	Tags           map[string]string
 	StartJoinAddrs []string
+	// EncryptKey is the base64 encoded key that encrypts gossip, it must be
+	// 16, 24 or 32 bytes long.
+	EncryptKey string
+	// KeyringFile persists the keys installed since, it's created from the
+	// EncryptKey on first start.
+	KeyringFile string
 }
 
 type Handler interface {
-	Join(name, addr string) error
+	Join(name, addr, role string) error
 	Leave(name string) error
+	Fail(name string) error
 }
 
 func New(handler Handler, config Config) (*Membership, error) {
@@ -33,6 +47,7 @@ func New(handler Handler, config Config) (*Membership, error) {
 		Config:  config,
 		handler: handler,
 		logger:  zap.L().Named("membership"),
+		reaped:  make(map[string]bool),
 	}
 	if err := c.setupSerf(); err != nil {
 		return nil, err
@@ -54,6 +69,9 @@ func (m *Membership) setupSerf() (err error) {
 	config.EventCh = m.events
 	config.Tags = m.Tags
 	config.NodeName = m.Config.NodeName
+	if err := m.setupKeyring(config); err != nil {
+		return err
+	}
 
 	m.serf, err = serf.Create(config)
 	if err != nil {
@@ -83,21 +101,42 @@ func (m *Membership) eventHandler() {
 				}
 				m.handleJoin(member)
 			}
-		case serf.EventMemberLeave, serf.EventMemberFailed:
+		case serf.EventMemberLeave:
 
 			for _, member := range e.(serf.MemberEvent).Members {
 				if m.isLocal(member) {
-					return
+					continue
 				}
 				m.handleLeave(member)
 			}
+		case serf.EventMemberFailed:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					continue
+				}
+				m.handleFail(member)
+			}
+		case serf.EventMemberReap:
+			for _, member := range e.(serf.MemberEvent).Members {
+				if m.isLocal(member) {
+					continue
+				}
+				m.mu.Lock()
+				m.reaped[member.Name] = true
+				m.mu.Unlock()
+				m.handleLeave(member)
+			}
 		}
 	}
 }
 func (m *Membership) handleJoin(member serf.Member) {
+	m.mu.Lock()
+	delete(m.reaped, member.Name)
+	m.mu.Unlock()
 	if err := m.handler.Join(
 		member.Name,
 		member.Tags["rpc_addr"],
+		member.Tags["role"],
 	); err != nil {
 		// TODO: Implement
 		m.logError(err, "failed to join", member)
@@ -112,6 +151,14 @@ func (m *Membership) handleLeave(member serf.Member) {
 	}
 }
 
+func (m *Membership) handleFail(member serf.Member) {
+	if err := m.handler.Fail(
+		member.Name,
+	); err != nil {
+		m.logError(err, "failed to handle failed member", member)
+	}
+}
+
 func (m *Membership) isLocal(member serf.Member) bool {
 	return m.serf.LocalMember().Name == member.Name
 }
@@ -120,6 +167,24 @@ func (m *Membership) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// Reaped reports whether serf forgot the member after it left or failed.
+// Servers serf never knew of weren't reaped, like those added to raft
+// directly.
+func (m *Membership) Reaped(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reaped[name]
+}
+
+// Coordinate returns the member's network coordinate, if serf knows it yet.
+func (m *Membership) Coordinate(name string) (*coordinate.Coordinate, bool) {
+	if name == m.serf.LocalMember().Name {
+		coord, err := m.serf.GetCoordinate()
+		return coord, err == nil
+	}
+	return m.serf.GetCachedCoordinate(name)
+}
+
 func (m *Membership) Leave() error {
 	return m.serf.Leave()
 }
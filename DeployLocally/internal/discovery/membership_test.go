@@ -1,7 +1,10 @@
 package discovery_test
 
 import (
+	"encoding/base64"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -40,11 +43,12 @@ type handler struct {
 	leaves chan string
 }
 
-func (h *handler) Join(id, addr string) error {
+func (h *handler) Join(id, addr, role string) error {
 	if h.joins != nil {
 		h.joins <- map[string]string{
 			"id":   id,
 			"addr": addr,
+			"role": role,
 		}
 	}
 	return nil
@@ -57,7 +61,56 @@ func (h *handler) Leave(id string) error {
 	return nil
 }
 
-func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler) {
+func (h *handler) Fail(id string) error {
+	return h.Leave(id)
+}
+
+func TestMembershipEncryption(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	newKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	encrypt := func(key string) func(*Config) {
+		return func(c *Config) {
+			c.EncryptKey = key
+			c.KeyringFile = filepath.Join(t.TempDir(), "serf", "keyring")
+		}
+	}
+
+	m, h := setupMember(t, nil, encrypt(key))
+	m, _ = setupMember(t, m, encrypt(key))
+	require.Eventually(t, func() bool {
+		return len(h.joins) == 1 && len(m[0].Members()) == 2
+	}, 3*time.Second, 250*time.Millisecond)
+
+	// servers without the key can't join
+	c := Config{
+		NodeName:       "intruder",
+		BindAddr:       fmt.Sprintf("127.0.0.1:%d", dynaport.Get(1)[0]),
+		StartJoinAddrs: []string{m[0].BindAddr},
+	}
+	_, err := New(&handler{}, c)
+	require.Error(t, err)
+
+	// rotate the key
+	require.NoError(t, m[0].InstallKey(newKey))
+	require.NoError(t, m[0].UseKey(newKey))
+	require.Error(t, m[0].RemoveKey(newKey))
+	require.NoError(t, m[0].RemoveKey(key))
+	keys, numServers, err := m[0].ListKeys()
+	require.NoError(t, err)
+	require.Equal(t, 2, numServers)
+	require.Equal(t, map[string]int{newKey: 2}, keys)
+
+	// the keyring file keeps the installed keys for restarts
+	b, err := os.ReadFile(m[1].KeyringFile)
+	require.NoError(t, err)
+	require.JSONEq(t, fmt.Sprintf(`["%s"]`, newKey), string(b))
+}
+
+func setupMember(
+	t *testing.T,
+	members []*Membership,
+	fns ...func(*Config),
+) ([]*Membership, *handler) {
 	id := len(members)
 	ports := dynaport.Get(1)
 	addr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
@@ -69,6 +122,9 @@ func setupMember(t *testing.T, members []*Membership) ([]*Membership, *handler)
 		BindAddr: addr,
 		Tags:     tags,
 	}
+	for _, fn := range fns {
+		fn(&c)
+	}
 	h := &handler{}
 
 	if len(members) == 0 {
@@ -0,0 +1,226 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// produce appends each partition's records at consecutive offsets. Records
+// only get their offsets once committed, so acks=1 waits for a quorum like
+// acks=all does.
+func (s *Server) produce(subject string, req *kmsg.ProduceRequest) *kmsg.ProduceResponse {
+	res := kmsg.NewPtrProduceResponse()
+	res.Version = req.Version
+	acks := api.Acks_ACKS_QUORUM
+	if req.Acks == 0 {
+		acks = api.Acks_ACKS_NONE
+	}
+	for _, t := range req.Topics {
+		topic := kmsg.NewProduceResponseTopic()
+		topic.Topic = t.Topic
+		for _, p := range t.Partitions {
+			partition := kmsg.NewProduceResponseTopicPartition()
+			partition.Partition = p.Partition
+			partition.BaseOffset = -1
+			partition.LogAppendTime = -1
+			partition.LogStartOffset = -1
+			partition.ErrorCode = s.producePartition(subject, t.Topic, p, acks, &partition)
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		res.Topics = append(res.Topics, topic)
+	}
+	return res
+}
+
+func (s *Server) producePartition(
+	subject string,
+	topic string,
+	p kmsg.ProduceRequestTopicPartition,
+	acks api.Acks,
+	res *kmsg.ProduceResponseTopicPartition,
+) int16 {
+	if s.authorize(subject, topic, produceAction) != nil {
+		return kerr.TopicAuthorizationFailed.Code
+	}
+	if topic != s.Topic || p.Partition != 0 {
+		return kerr.UnknownTopicOrPartition.Code
+	}
+	records, err := decodeBatches(p.Records)
+	if errors.Is(err, errUnsupportedCompression) {
+		return kerr.UnsupportedCompressionType.Code
+	} else if err != nil {
+		return kerr.CorruptMessage.Code
+	}
+	if len(records) == 0 {
+		return 0
+	}
+	offset, _, err := s.Log.AppendRecords(records, acks)
+	if errors.Is(err, raft.ErrNotLeader) {
+		return kerr.NotLeaderForPartition.Code
+	} else if err != nil {
+		zap.L().Named("kafka").Error("failed to append records", zap.Error(err))
+		return kerr.UnknownServerError.Code
+	}
+	res.BaseOffset = int64(offset)
+	if lowest, err := s.Log.StartOffset(api.Start_START_EARLIEST, 0); err == nil {
+		res.LogStartOffset = int64(lowest)
+	}
+	return 0
+}
+
+// fetch reads the partition's records from the requested offset, waiting
+// for them when they're yet to be appended.
+func (s *Server) fetch(subject string, req *kmsg.FetchRequest) *kmsg.FetchResponse {
+	maxWait := time.Duration(req.MaxWaitMillis) * time.Millisecond
+	if maxWait > s.MaxFetchWait {
+		maxWait = s.MaxFetchWait
+	}
+	ctx, cancel := context.WithTimeout(s.Context(), maxWait)
+	defer cancel()
+	for {
+		res, waitOffset, wait := s.fetchOnce(subject, req)
+		if !wait || s.Log.Wait(ctx, waitOffset) != nil {
+			return res
+		}
+	}
+}
+
+// fetchOnce returns what there is to fetch, or the offset to wait for when
+// there's nothing to fetch yet.
+func (s *Server) fetchOnce(
+	subject string,
+	req *kmsg.FetchRequest,
+) (*kmsg.FetchResponse, uint64, bool) {
+	res := kmsg.NewPtrFetchResponse()
+	res.Version = req.Version
+	maxBytes := int(req.MaxBytes)
+	var waitOffset uint64
+	wait, fetched := false, false
+	for _, t := range req.Topics {
+		topic := kmsg.NewFetchResponseTopic()
+		topic.Topic = t.Topic
+		authErr := s.authorize(subject, t.Topic, consumeAction)
+		for _, p := range t.Partitions {
+			partition := kmsg.NewFetchResponseTopicPartition()
+			partition.Partition = p.Partition
+			partition.HighWatermark = -1
+			partition.LastStableOffset = -1
+			partition.LogStartOffset = -1
+			switch {
+			case authErr != nil:
+				partition.ErrorCode = kerr.TopicAuthorizationFailed.Code
+			case t.Topic != s.Topic || p.Partition != 0:
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+			default:
+				limit := int(p.PartitionMaxBytes)
+				if maxBytes > 0 && maxBytes < limit {
+					limit = maxBytes
+				}
+				size := s.fetchPartition(p.FetchOffset, limit, &partition)
+				maxBytes -= size
+				if size == 0 && partition.ErrorCode == 0 {
+					wait = true
+					waitOffset = uint64(p.FetchOffset)
+				}
+			}
+			fetched = fetched || partition.ErrorCode != 0 || len(partition.RecordBatches) > 0
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		res.Topics = append(res.Topics, topic)
+	}
+	return res, waitOffset, wait && !fetched
+}
+
+// fetchPartition reads the records from the offset on, at least one however
+// big it is, up to maxBytes. It returns the size of the records it read.
+func (s *Server) fetchPartition(
+	offset int64,
+	maxBytes int,
+	res *kmsg.FetchResponseTopicPartition,
+) int {
+	lowest, err := s.Log.StartOffset(api.Start_START_EARLIEST, 0)
+	if err != nil {
+		res.ErrorCode = kerr.UnknownServerError.Code
+		return 0
+	}
+	next, err := s.Log.StartOffset(api.Start_START_LATEST, 0)
+	if err != nil {
+		res.ErrorCode = kerr.UnknownServerError.Code
+		return 0
+	}
+	res.HighWatermark = int64(next)
+	res.LastStableOffset = int64(next)
+	res.LogStartOffset = int64(lowest)
+	if offset < int64(lowest) || offset > int64(next) {
+		res.ErrorCode = kerr.OffsetOutOfRange.Code
+		return 0
+	}
+
+	var records []*api.Record
+	size := 0
+	for off := uint64(offset); off < next; off++ {
+		record, err := s.Log.Read(off)
+		if err != nil {
+			break
+		}
+		recordSize := proto.Size(record)
+		if len(records) > 0 && size+recordSize > maxBytes {
+			break
+		}
+		records = append(records, record)
+		size += recordSize
+	}
+	if len(records) > 0 {
+		res.RecordBatches = encodeBatch(uint64(offset), records)
+	}
+	return size
+}
+
+// listOffsets resolves the earliest and latest offsets, the log doesn't
+// index its records by their timestamps.
+func (s *Server) listOffsets(subject string, req *kmsg.ListOffsetsRequest) *kmsg.ListOffsetsResponse {
+	res := kmsg.NewPtrListOffsetsResponse()
+	res.Version = req.Version
+	for _, t := range req.Topics {
+		topic := kmsg.NewListOffsetsResponseTopic()
+		topic.Topic = t.Topic
+		authErr := s.authorize(subject, t.Topic, describeAction)
+		for _, p := range t.Partitions {
+			partition := kmsg.NewListOffsetsResponseTopicPartition()
+			partition.Partition = p.Partition
+			partition.Timestamp = -1
+			partition.Offset = -1
+			partition.LeaderEpoch = -1
+			switch {
+			case authErr != nil:
+				partition.ErrorCode = kerr.TopicAuthorizationFailed.Code
+			case t.Topic != s.Topic || p.Partition != 0:
+				partition.ErrorCode = kerr.UnknownTopicOrPartition.Code
+			case p.Timestamp == -2 || p.Timestamp == -1:
+				start := api.Start_START_EARLIEST
+				if p.Timestamp == -1 {
+					start = api.Start_START_LATEST
+				}
+				offset, err := s.Log.StartOffset(start, 0)
+				if err != nil {
+					partition.ErrorCode = kerr.UnknownServerError.Code
+					break
+				}
+				partition.Offset = int64(offset)
+			default:
+				partition.ErrorCode = kerr.InvalidRequest.Code
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		res.Topics = append(res.Topics, topic)
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const (
+	// batchHeaderSize is the size of a record batch up to its records,
+	// Length covers what follows its own field, from the 12th byte on.
+	batchHeaderSize = 61
+	// the CRC covers the batch from the attributes on
+	crcOffset   = 17
+	attrsOffset = 21
+
+	compressionMask   = 0x07
+	compressionGzip   = 1
+	controlAttributes = 0x20
+)
+
+var (
+	castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+	errUnsupportedCompression = errors.New("unsupported compression")
+)
+
+// decodeBatches reads the records out of the produced record batches. Only
+// uncompressed and gzip compressed batches are supported, and together they
+// can't decompress to more than a request can carry.
+func decodeBatches(b []byte) ([]*api.Record, error) {
+	var records []*api.Record
+	decompressed := int64(0)
+	for len(b) > 0 {
+		if len(b) < batchHeaderSize {
+			return nil, fmt.Errorf("record batch too short")
+		}
+		end := 12 + int(int32(binary.BigEndian.Uint32(b[8:])))
+		if end < batchHeaderSize || end > len(b) {
+			return nil, fmt.Errorf("invalid record batch length")
+		}
+		batch := kmsg.RecordBatch{}
+		if err := batch.ReadFrom(b[:end]); err != nil {
+			return nil, err
+		}
+		if batch.Magic != 2 {
+			return nil, fmt.Errorf("unsupported record batch magic: %d", batch.Magic)
+		}
+		if crc32.Checksum(b[attrsOffset:end], castagnoli) != uint32(batch.CRC) {
+			return nil, fmt.Errorf("record batch CRC mismatch")
+		}
+		b = b[end:]
+		if batch.Attributes&controlAttributes != 0 {
+			continue
+		}
+
+		raw := batch.Records
+		switch batch.Attributes & compressionMask {
+		case 0:
+		case compressionGzip:
+			r, err := gzip.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, err
+			}
+			if raw, err = io.ReadAll(io.LimitReader(r, maxRequestSize-decompressed+1)); err != nil {
+				return nil, err
+			}
+			if decompressed += int64(len(raw)); decompressed > maxRequestSize {
+				return nil, fmt.Errorf("record batches decompress past %d bytes", maxRequestSize)
+			}
+		default:
+			return nil, errUnsupportedCompression
+		}
+
+		for i := int32(0); i < batch.NumRecords; i++ {
+			size, n := binary.Varint(raw)
+			if n <= 0 || size < 0 || int64(len(raw)-n) < size {
+				return nil, fmt.Errorf("invalid record length")
+			}
+			record := kmsg.Record{}
+			if err := record.ReadFrom(raw[:n+int(size)]); err != nil {
+				return nil, err
+			}
+			raw = raw[n+int(size):]
+			r := &api.Record{
+				Key:         record.Key,
+				Value:       record.Value,
+				TimestampMs: batch.FirstTimestamp + record.TimestampDelta64,
+			}
+			for _, h := range record.Headers {
+				r.Headers = append(r.Headers, &api.Header{Key: h.Key, Value: h.Value})
+			}
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// encodeBatch puts the records, starting at the offset, in an uncompressed
+// record batch.
+func encodeBatch(offset uint64, records []*api.Record) []byte {
+	first, max := records[0].TimestampMs, records[0].TimestampMs
+	var raw []byte
+	for i, r := range records {
+		record := kmsg.Record{
+			TimestampDelta64: r.TimestampMs - first,
+			OffsetDelta:      int32(i),
+			Key:              r.Key,
+			Value:            r.Value,
+		}
+		for _, h := range r.Headers {
+			record.Headers = append(record.Headers, kmsg.Header{Key: h.Key, Value: h.Value})
+		}
+		// the length doesn't count itself, which takes a byte while it's 0
+		record.Length = int32(len(record.AppendTo(nil)) - 1)
+		raw = record.AppendTo(raw)
+		if r.TimestampMs > max {
+			max = r.TimestampMs
+		}
+	}
+	batch := kmsg.RecordBatch{
+		FirstOffset:          int64(offset),
+		Length:               int32(batchHeaderSize - 12 + len(raw)),
+		PartitionLeaderEpoch: -1,
+		Magic:                2,
+		LastOffsetDelta:      int32(len(records) - 1),
+		FirstTimestamp:       first,
+		MaxTimestamp:         max,
+		ProducerID:           -1,
+		ProducerEpoch:        -1,
+		FirstSequence:        -1,
+		NumRecords:           int32(len(records)),
+		Records:              raw,
+	}
+	b := batch.AppendTo(nil)
+	binary.BigEndian.PutUint32(b[crcOffset:], crc32.Checksum(b[attrsOffset:], castagnoli))
+	return b
+}
@@ -0,0 +1,43 @@
+package kafka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestDecodeBatchesLimitsDecompression(t *testing.T) {
+	// a small batch that decompresses past what a request can carry
+	compressed := &bytes.Buffer{}
+	w := gzip.NewWriter(compressed)
+	_, err := io.Copy(w, io.LimitReader(zeros{}, maxRequestSize+1))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	batch := kmsg.RecordBatch{}
+	require.NoError(t, batch.ReadFrom(encodeBatch(0, []*api.Record{{Value: []byte("a")}})))
+	batch.Attributes = compressionGzip
+	batch.Records = compressed.Bytes()
+	batch.Length = int32(batchHeaderSize - 12 + len(batch.Records))
+	b := batch.AppendTo(nil)
+	binary.BigEndian.PutUint32(b[crcOffset:], crc32.Checksum(b[attrsOffset:], castagnoli))
+
+	// errors other than unsupported compression are CORRUPT_MESSAGE
+	_, err = decodeBatches(b)
+	require.ErrorContains(t, err, "decompress past")
+	require.NotErrorIs(t, err, errUnsupportedCompression)
+}
@@ -0,0 +1,369 @@
+// Package kafka serves the log over a subset of Kafka's wire protocol, so
+// Kafka clients can produce to and fetch from it. The log shows up as a
+// topic with a single partition, 0. The server answers ApiVersions,
+// Metadata, Produce, Fetch and ListOffsets. There are no consumer groups, so
+// consumers assign the partition themselves and keep track of their offsets.
+package kafka
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/connserver"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"go.uber.org/zap"
+)
+
+// Log is the log the Kafka clients produce to and fetch from.
+type Log interface {
+	AppendRecords([]*api.Record, api.Acks) (uint64, api.Acks, error)
+	Read(uint64) (*api.Record, error)
+	StartOffset(api.Start, uint64) (uint64, error)
+	Wait(ctx context.Context, offset uint64) error
+}
+
+type Authorizer interface {
+	Authorize(subject, object, action string) error
+}
+
+// Cluster lists the servers the Kafka clients can connect to.
+type Cluster interface {
+	Brokers() ([]Broker, error)
+}
+
+// Broker is a server that serves the Kafka protocol, Addr is where it does.
+type Broker struct {
+	ID     string
+	Addr   string
+	Rack   string
+	Leader bool
+}
+
+type Config struct {
+	Log        Log
+	Authorizer Authorizer
+	// CertAuthenticator tells who clients are by their certificates, by
+	// their common name by default.
+	CertAuthenticator auth.CertAuthenticator
+	Cluster           Cluster
+	// Topic is the name the log goes by, "proglog" when empty.
+	Topic string
+	// MaxFetchWait caps how long fetches wait for records yet to be
+	// appended, 30s when 0.
+	MaxFetchWait time.Duration
+}
+
+const (
+	defaultTopic        = "proglog"
+	defaultMaxFetchWait = 30 * time.Second
+	clusterID           = "proglog"
+	maxRequestSize      = 100 << 20
+
+	produceAction  = "produce"
+	consumeAction  = "consume"
+	describeAction = "describe"
+)
+
+// authorize checks the subject may act on the topic, the ACLs know topics as
+// topics/<topic>.
+func (s *Server) authorize(subject, topic, action string) error {
+	return s.Authorizer.Authorize(subject, "topics/"+topic, action)
+}
+
+type versions struct {
+	min, max int16
+}
+
+// supported are the versions of the requests the server answers. They start
+// with the first versions holding records in batches, and stop before topics
+// are referred to by their ID.
+var supported = map[int16]versions{
+	kmsg.Produce.Int16():     {3, 9},
+	kmsg.Fetch.Int16():       {4, 12},
+	kmsg.ListOffsets.Int16(): {1, 7},
+	kmsg.Metadata.Int16():    {0, 9},
+	kmsg.ApiVersions.Int16(): {0, 3},
+}
+
+type Server struct {
+	Config
+	*connserver.Server
+}
+
+func NewServer(config Config) (*Server, error) {
+	if config.Log == nil {
+		return nil, fmt.Errorf("kafka server needs a log")
+	}
+	if config.Authorizer == nil {
+		return nil, fmt.Errorf("kafka server needs an authorizer")
+	}
+	if config.Cluster == nil {
+		return nil, fmt.Errorf("kafka server needs the cluster")
+	}
+	if config.Topic == "" {
+		config.Topic = defaultTopic
+	}
+	if config.MaxFetchWait == 0 {
+		config.MaxFetchWait = defaultMaxFetchWait
+	}
+	s := &Server{Config: config}
+	s.Server = connserver.New(s.serveConn)
+	return s, nil
+}
+
+// serveConn answers the connection's requests in order, Kafka clients
+// expect the responses in the order they sent the requests.
+func (s *Server) serveConn(conn net.Conn) {
+	logger := zap.L().Named("kafka")
+	subject, err := connserver.Subject(conn, s.CertAuthenticator)
+	if err != nil {
+		logger.Debug("handshake failed", zap.Error(err))
+		return
+	}
+	r := bufio.NewReader(conn)
+	for {
+		var size int32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("failed to read request", zap.Error(err))
+			}
+			return
+		}
+		if size < 0 || size > maxRequestSize {
+			logger.Debug("request too big", zap.Int32("size", size))
+			return
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(r, b); err != nil {
+			logger.Debug("failed to read request", zap.Error(err))
+			return
+		}
+		res, err := s.handleRequest(subject, b)
+		if err != nil {
+			logger.Debug("failed to handle request", zap.Error(err))
+			return
+		}
+		if res == nil {
+			continue
+		}
+		if _, err := conn.Write(res); err != nil {
+			return
+		}
+	}
+}
+
+// handleRequest parses the request from its header on and returns the
+// response, size included. There's no response to produce requests without
+// acks.
+func (s *Server) handleRequest(subject string, b []byte) ([]byte, error) {
+	if len(b) < 10 {
+		return nil, fmt.Errorf("request header too short")
+	}
+	key := int16(binary.BigEndian.Uint16(b))
+	version := int16(binary.BigEndian.Uint16(b[2:]))
+	correlationID := binary.BigEndian.Uint32(b[4:])
+	clientID := int16(binary.BigEndian.Uint16(b[8:]))
+	b = b[10:]
+	if clientID > 0 {
+		if int(clientID) > len(b) {
+			return nil, fmt.Errorf("request header too short")
+		}
+		b = b[clientID:]
+	}
+
+	req := kmsg.RequestForKey(key)
+	if req == nil {
+		return nil, fmt.Errorf("unknown request key: %d", key)
+	}
+	v, ok := supported[key]
+	if !ok || version < v.min || version > v.max {
+		// clients ask for the versions with the newest version they know,
+		// and fall back to the version the error comes in
+		if key == kmsg.ApiVersions.Int16() {
+			res := s.apiVersions(&kmsg.ApiVersionsRequest{})
+			res.ErrorCode = kerr.UnsupportedVersion.Code
+			return response(correlationID, res), nil
+		}
+		return nil, fmt.Errorf(
+			"unsupported version %d of %s",
+			version,
+			kmsg.NameForKey(key),
+		)
+	}
+	req.SetVersion(version)
+	if req.IsFlexible() {
+		var err error
+		if b, err = skipTags(b); err != nil {
+			return nil, err
+		}
+	}
+	if err := req.ReadFrom(b); err != nil {
+		return nil, err
+	}
+
+	var res kmsg.Response
+	switch req := req.(type) {
+	case *kmsg.ApiVersionsRequest:
+		res = s.apiVersions(req)
+	case *kmsg.MetadataRequest:
+		res = s.metadata(subject, req)
+	case *kmsg.ProduceRequest:
+		res = s.produce(subject, req)
+		if req.Acks == 0 {
+			return nil, nil
+		}
+	case *kmsg.FetchRequest:
+		res = s.fetch(subject, req)
+	case *kmsg.ListOffsetsRequest:
+		res = s.listOffsets(subject, req)
+	}
+	return response(correlationID, res), nil
+}
+
+func skipTags(b []byte) ([]byte, error) {
+	n, read := binary.Uvarint(b)
+	if read <= 0 {
+		return nil, fmt.Errorf("invalid tagged fields")
+	}
+	b = b[read:]
+	for i := uint64(0); i < n; i++ {
+		if _, read = binary.Uvarint(b); read <= 0 {
+			return nil, fmt.Errorf("invalid tagged fields")
+		}
+		b = b[read:]
+		size, read := binary.Uvarint(b)
+		if read <= 0 || uint64(len(b)-read) < size {
+			return nil, fmt.Errorf("invalid tagged fields")
+		}
+		b = b[uint64(read)+size:]
+	}
+	return b, nil
+}
+
+func response(correlationID uint32, res kmsg.Response) []byte {
+	b := make([]byte, 8, 64)
+	binary.BigEndian.PutUint32(b[4:], correlationID)
+	// ApiVersions responses never have tagged fields in their header, so
+	// clients can read them whatever version they asked for
+	if res.IsFlexible() && res.Key() != kmsg.ApiVersions.Int16() {
+		b = append(b, 0)
+	}
+	b = res.AppendTo(b)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b
+}
+
+func (s *Server) apiVersions(req *kmsg.ApiVersionsRequest) *kmsg.ApiVersionsResponse {
+	res := kmsg.NewPtrApiVersionsResponse()
+	res.Version = req.Version
+	for key, v := range supported {
+		apiKey := kmsg.NewApiVersionsResponseApiKey()
+		apiKey.ApiKey = key
+		apiKey.MinVersion = v.min
+		apiKey.MaxVersion = v.max
+		res.ApiKeys = append(res.ApiKeys, apiKey)
+	}
+	sort.Slice(res.ApiKeys, func(i, j int) bool {
+		return res.ApiKeys[i].ApiKey < res.ApiKeys[j].ApiKey
+	})
+	return res
+}
+
+// metadata describes the brokers and the log's topic, whose partition's
+// leader is raft's leader.
+func (s *Server) metadata(subject string, req *kmsg.MetadataRequest) *kmsg.MetadataResponse {
+	res := kmsg.NewPtrMetadataResponse()
+	res.Version = req.Version
+	cluster := clusterID
+	res.ClusterID = &cluster
+
+	leader := int32(-1)
+	var replicas []int32
+	brokers, err := s.Cluster.Brokers()
+	if err != nil {
+		zap.L().Named("kafka").Error("failed to list brokers", zap.Error(err))
+	}
+	for _, b := range brokers {
+		host, port, err := net.SplitHostPort(b.Addr)
+		if err != nil {
+			continue
+		}
+		p, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			continue
+		}
+		broker := kmsg.NewMetadataResponseBroker()
+		broker.NodeID = nodeID(b.ID)
+		broker.Host = host
+		broker.Port = int32(p)
+		if b.Rack != "" {
+			rack := b.Rack
+			broker.Rack = &rack
+		}
+		res.Brokers = append(res.Brokers, broker)
+		replicas = append(replicas, broker.NodeID)
+		if b.Leader {
+			leader = broker.NodeID
+		}
+	}
+	res.ControllerID = leader
+
+	// v0 asks for every topic without topics, later versions with null.
+	// Every topic leaves out the ones the subject can't describe.
+	var topics []string
+	if req.Topics != nil && (req.Version > 0 || len(req.Topics) > 0) {
+		for _, t := range req.Topics {
+			if t.Topic != nil {
+				topics = append(topics, *t.Topic)
+			}
+		}
+	} else if s.authorize(subject, s.Topic, describeAction) == nil {
+		topics = append(topics, s.Topic)
+	}
+	for _, name := range topics {
+		name := name
+		topic := kmsg.NewMetadataResponseTopic()
+		topic.Topic = &name
+		if s.authorize(subject, name, describeAction) != nil {
+			topic.ErrorCode = kerr.TopicAuthorizationFailed.Code
+			res.Topics = append(res.Topics, topic)
+			continue
+		}
+		if name != s.Topic {
+			topic.ErrorCode = kerr.UnknownTopicOrPartition.Code
+			res.Topics = append(res.Topics, topic)
+			continue
+		}
+		partition := kmsg.NewMetadataResponseTopicPartition()
+		partition.Partition = 0
+		partition.Leader = leader
+		partition.LeaderEpoch = -1
+		partition.Replicas = replicas
+		partition.ISR = replicas
+		if leader == -1 {
+			partition.ErrorCode = kerr.LeaderNotAvailable.Code
+		}
+		topic.Partitions = append(topic.Partitions, partition)
+		res.Topics = append(res.Topics, topic)
+	}
+	return res
+}
+
+// nodeID gives servers the numeric IDs Kafka identifies brokers by.
+func nodeID(id string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int32(h.Sum32() & 0x7fffffff)
+}
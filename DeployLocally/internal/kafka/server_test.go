@@ -0,0 +1,133 @@
+package kafka_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/config"
+	"github.com/madalosso/proglog/internal/kafka"
+	"github.com/madalosso/proglog/internal/log"
+	"github.com/madalosso/proglog/internal/log/logtest"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const topic = "proglog"
+
+type cluster struct {
+	addr string
+}
+
+func (c *cluster) Brokers() ([]kafka.Broker, error) {
+	return []kafka.Broker{{ID: "0", Addr: c.addr, Leader: true}}, nil
+}
+
+func TestServer(t *testing.T) {
+	l := logtest.NewLeader(t)
+	addr := setupServer(t, l)
+
+	root := setupClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile,
+		kgo.ProducerBatchCompression(kgo.GzipCompression()),
+	)
+	ctx := context.Background()
+	var records []*kgo.Record
+	for i := 0; i < 3; i++ {
+		records = append(records, &kgo.Record{
+			Topic:   topic,
+			Key:     []byte(fmt.Sprintf("key-%d", i)),
+			Value:   []byte(fmt.Sprintf("value-%d", i)),
+			Headers: []kgo.RecordHeader{{Key: "header", Value: []byte("value")}},
+		})
+	}
+	require.NoError(t, root.ProduceSync(ctx, records...).FirstErr())
+	for i, record := range records {
+		require.Equal(t, int64(i), record.Offset)
+	}
+
+	// records produced through other APIs come through too
+	_, err := l.Append(&api.Record{Value: []byte("grpc")})
+	require.NoError(t, err)
+
+	consumer := setupClient(t, addr, config.RootClientCertFile, config.RootClientKeyFile,
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{
+			topic: {0: kgo.NewOffset().AtStart()},
+		}),
+	)
+	var fetched []*kgo.Record
+	for len(fetched) < 4 {
+		fetchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		fetches := consumer.PollFetches(fetchCtx)
+		cancel()
+		require.NoError(t, fetches.Err())
+		fetched = append(fetched, fetches.Records()...)
+	}
+	for i, record := range fetched[:3] {
+		require.Equal(t, int64(i), record.Offset)
+		require.Equal(t, records[i].Key, record.Key)
+		require.Equal(t, records[i].Value, record.Value)
+		require.Equal(t, records[i].Headers, record.Headers)
+		require.Equal(t, records[i].Timestamp.UnixMilli(), record.Timestamp.UnixMilli())
+	}
+	require.Equal(t, int64(3), fetched[3].Offset)
+	require.Equal(t, []byte("grpc"), fetched[3].Value)
+
+	nobody := setupClient(t, addr, config.NobodyClientCertFile, config.NobodyClientKeyFile)
+	err = nobody.ProduceSync(ctx, &kgo.Record{Topic: topic, Value: []byte("denied")}).FirstErr()
+	require.ErrorIs(t, err, kerr.TopicAuthorizationFailed)
+}
+
+func setupServer(t *testing.T, l *log.DistributedLog) string {
+	t.Helper()
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv, err := kafka.NewServer(kafka.Config{
+		Log:        l,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Cluster:    &cluster{addr: ln.Addr().String()},
+		Topic:      topic,
+	})
+	require.NoError(t, err)
+	go func() {
+		_ = srv.Serve(tls.NewListener(ln, serverTLSConfig))
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+	return ln.Addr().String()
+}
+
+func setupClient(t *testing.T, addr, certFile, keyFile string, opts ...kgo.Opt) *kgo.Client {
+	t.Helper()
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	opts = append([]kgo.Opt{
+		kgo.SeedBrokers(addr),
+		kgo.DialTLSConfig(tlsConfig),
+		kgo.DefaultProduceTopic(topic),
+		kgo.RecordDeliveryTimeout(5 * time.Second),
+	}, opts...)
+	client, err := kgo.NewClient(opts...)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+	return client
+}
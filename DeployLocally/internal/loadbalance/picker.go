@@ -4,7 +4,9 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	api "github.com/madalosso/proglog/api/v1"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 )
@@ -16,22 +18,73 @@ type Picker struct {
 	mu        sync.RWMutex
 	leader    balancer.SubConn
 	followers []balancer.SubConn
-	current   uint64
+	nonvoters []balancer.SubConn
+	// the followers and non-voters in the client's zone
+	localFollowers []balancer.SubConn
+	localNonvoters []balancer.SubConn
+	// rtts are the round trip times estimated from the network coordinates
+	rtts    map[balancer.SubConn]time.Duration
+	current uint64
+
+	// latencies are the EWMAs of the consume calls' latencies, used when
+	// there are no estimates
+	latencyMu sync.Mutex
+	latencies map[balancer.SubConn]float64
 }
 
+// ewmaWeight is how much a new latency weighs in its server's average.
+const ewmaWeight = 0.2
+
+// spread is how many times the best round trip time or latency a server can
+// take and still share the consumes, so they don't all go to the one server
+// that's marginally closer.
+const spread = 1.25
+
 func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	var followers []balancer.SubConn
+	var followers, nonvoters []balancer.SubConn
+	var localFollowers, localNonvoters []balancer.SubConn
+	rtts := make(map[balancer.SubConn]time.Duration)
 	for sc, scInfo := range buildInfo.ReadySCs {
-		isLeader := scInfo.Address.Attributes.Value("is_leader").(bool)
+		attrs := scInfo.Address.Attributes
+		if rtt, ok := attrs.Value("rtt").(time.Duration); ok {
+			rtts[sc] = rtt
+		}
+		isLeader := attrs.Value("is_leader").(bool)
 		if isLeader {
 			p.leader = sc
 			continue
 		}
+		zone, _ := attrs.Value("zone").(string)
+		clientZone, _ := attrs.Value("client_zone").(string)
+		local := zone != "" && zone == clientZone
+		// servers that don't report a role are treated as voters
+		if role, _ := attrs.Value("role").(string); role == api.RoleNonvoter {
+			nonvoters = append(nonvoters, sc)
+			if local {
+				localNonvoters = append(localNonvoters, sc)
+			}
+			continue
+		}
 		followers = append(followers, sc)
+		if local {
+			localFollowers = append(localFollowers, sc)
+		}
 	}
 	p.followers = followers
+	p.nonvoters = nonvoters
+	p.localFollowers = localFollowers
+	p.localNonvoters = localNonvoters
+	p.rtts = rtts
+
+	p.latencyMu.Lock()
+	for sc := range p.latencies {
+		if _, ok := buildInfo.ReadySCs[sc]; !ok {
+			delete(p.latencies, sc)
+		}
+	}
+	p.latencyMu.Unlock()
 	return p
 }
 
@@ -39,10 +92,33 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	var result balancer.PickResult
-	if strings.Contains(info.FullMethodName, "Produce") || len(p.followers) == 0 {
+	if strings.Contains(info.FullMethodName, "Produce") ||
+		len(p.followers)+len(p.nonvoters) == 0 {
 		result.SubConn = p.leader
 	} else if strings.Contains(info.FullMethodName, "Consume") {
-		result.SubConn = p.nextFollower()
+		// prefer servers in the client's zone to keep reads from crossing
+		// zones, then read replicas so consumers don't load the voters
+		for _, subConns := range [][]balancer.SubConn{
+			p.localNonvoters,
+			p.localFollowers,
+			p.nonvoters,
+			p.followers,
+		} {
+			if len(subConns) > 0 {
+				result.SubConn = p.nearest(subConns)
+				break
+			}
+		}
+		// streams last as long as the consumer, only calls tell the latency
+		if sc := result.SubConn; sc != nil &&
+			strings.HasSuffix(info.FullMethodName, "/Consume") {
+			start := time.Now()
+			result.Done = func(info balancer.DoneInfo) {
+				if info.Err == nil {
+					p.observe(sc, time.Since(start))
+				}
+			}
+		}
 	}
 	if result.SubConn == nil {
 		return result, balancer.ErrNoSubConnAvailable
@@ -50,13 +126,90 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	return result, nil
 }
 
-func (p *Picker) nextFollower() balancer.SubConn {
+// nearest picks among the servers with the lowest estimated round trip
+// times, or the fastest to answer when some servers have no estimate.
+func (p *Picker) nearest(subConns []balancer.SubConn) balancer.SubConn {
+	var best time.Duration
+	for i, sc := range subConns {
+		rtt, ok := p.rtts[sc]
+		if !ok {
+			return p.fastest(subConns)
+		}
+		if i == 0 || rtt < best {
+			best = rtt
+		}
+	}
+	var nearest []balancer.SubConn
+	for _, sc := range subConns {
+		if float64(p.rtts[sc]) <= float64(best)*spread {
+			nearest = append(nearest, sc)
+		}
+	}
+	return p.next(nearest)
+}
+
+// fastest picks among the servers with the lowest average latencies.
+// Servers that haven't answered yet are tried first.
+func (p *Picker) fastest(subConns []balancer.SubConn) balancer.SubConn {
+	p.latencyMu.Lock()
+	defer p.latencyMu.Unlock()
+	var unmeasured []balancer.SubConn
+	best := -1.0
+	for _, sc := range subConns {
+		latency, ok := p.latencies[sc]
+		if !ok {
+			unmeasured = append(unmeasured, sc)
+			continue
+		}
+		if best < 0 || latency < best {
+			best = latency
+		}
+	}
+	if len(unmeasured) > 0 {
+		return p.next(unmeasured)
+	}
+	var fastest []balancer.SubConn
+	for _, sc := range subConns {
+		if p.latencies[sc] <= best*spread {
+			fastest = append(fastest, sc)
+		}
+	}
+	return p.next(fastest)
+}
+
+func (p *Picker) observe(sc balancer.SubConn, latency time.Duration) {
+	p.latencyMu.Lock()
+	defer p.latencyMu.Unlock()
+	if p.latencies == nil {
+		p.latencies = make(map[balancer.SubConn]float64)
+	}
+	avg, ok := p.latencies[sc]
+	if !ok {
+		p.latencies[sc] = float64(latency)
+		return
+	}
+	p.latencies[sc] = ewmaWeight*float64(latency) + (1-ewmaWeight)*avg
+}
+
+func (p *Picker) next(subConns []balancer.SubConn) balancer.SubConn {
 	cur := atomic.AddUint64(&p.current, uint64(1))
-	len := uint64(len(p.followers))
+	len := uint64(len(subConns))
 	idx := int(cur % len)
-	return p.followers[idx]
+	return subConns[idx]
+}
+
+// builder gives every client connection a Picker of its own, the latencies
+// it measures are to its own sub conns.
+type builder struct{}
+
+func (builder) Name() string {
+	return Name
+}
+
+func (builder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	return base.NewBalancerBuilder(Name, &Picker{}, base.Config{}).Build(cc, opts)
 }
 
 func init() {
-	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+	balancer.Register(builder{})
 }
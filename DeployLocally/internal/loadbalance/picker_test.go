@@ -2,7 +2,9 @@ package loadbalance_test
 
 import (
 	"testing"
+	"time"
 
+	api "github.com/madalosso/proglog/api/v1"
 	"github.com/madalosso/proglog/internal/loadbalance"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/attributes"
@@ -50,6 +52,180 @@ func TestPickerConsumesFromFollowers(t *testing.T) {
 	}
 }
 
+func TestPickerConsumesFromNonvoters(t *testing.T) {
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []*subConn
+	for i, role := range []string{api.RoleVoter, api.RoleVoter, api.RoleNonvoter} {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New("is_leader", i == 0, "role", role),
+		}
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	picker := &loadbalance.Picker{}
+	picker.Build(buildInfo)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[2], gotPick.SubConn)
+	}
+
+	info.FullMethodName = "/log.vX.Log/Produce"
+	gotPick, err := picker.Pick(info)
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], gotPick.SubConn)
+}
+
+func TestPickerConsumesFromClientZone(t *testing.T) {
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []*subConn
+	for i, server := range []struct {
+		role, zone string
+	}{
+		{api.RoleVoter, "a"},
+		{api.RoleVoter, "a"},
+		{api.RoleVoter, "b"},
+		{api.RoleNonvoter, "c"},
+	} {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New(
+				"is_leader", i == 0,
+				"role", server.role,
+				"zone", server.zone,
+				"client_zone", "b",
+			),
+		}
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	picker := &loadbalance.Picker{}
+	picker.Build(buildInfo)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+	// the follower in the client's zone wins over the read replica elsewhere
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[2], gotPick.SubConn)
+	}
+
+	// without a server in the client's zone, fall back to the others
+	delete(buildInfo.ReadySCs, subConns[2])
+	picker.Build(buildInfo)
+	gotPick, err := picker.Pick(info)
+	require.NoError(t, err)
+	require.Equal(t, subConns[3], gotPick.SubConn)
+}
+
+func TestPickerConsumesFromNearest(t *testing.T) {
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []*subConn
+	for i, rtt := range []time.Duration{
+		0,
+		30 * time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+	} {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New("is_leader", i == 0, "rtt", rtt),
+		}
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	picker := &loadbalance.Picker{}
+	picker.Build(buildInfo)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.Equal(t, subConns[2], gotPick.SubConn)
+	}
+}
+
+func TestPickerSpreadsConsumesAmongNearest(t *testing.T) {
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	var subConns []*subConn
+	for i, rtt := range []time.Duration{
+		0,
+		5 * time.Millisecond,
+		6 * time.Millisecond,
+		30 * time.Millisecond,
+	} {
+		sc := &subConn{}
+		addr := resolver.Address{
+			Attributes: attributes.New("is_leader", i == 0, "rtt", rtt),
+		}
+		sc.UpdateAddresses([]resolver.Address{addr})
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		subConns = append(subConns, sc)
+	}
+	picker := &loadbalance.Picker{}
+	picker.Build(buildInfo)
+
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+	// the servers about as near as the nearest share its consumes
+	picked := make(map[balancer.SubConn]int)
+	for i := 0; i < 10; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		picked[gotPick.SubConn]++
+	}
+	require.Equal(t, 5, picked[subConns[1]])
+	require.Equal(t, 5, picked[subConns[2]])
+	require.Zero(t, picked[subConns[3]])
+}
+
+func TestPickerConsumesFromFastest(t *testing.T) {
+	picker, subConns := setupTest()
+	info := balancer.PickInfo{
+		FullMethodName: "/log.vX.Log/Consume",
+	}
+
+	// without estimates every follower gets tried, the slow one first
+	slow, err := picker.Pick(info)
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	slow.Done(balancer.DoneInfo{})
+	fast, err := picker.Pick(info)
+	require.NoError(t, err)
+	// the fake sub conns are equal by value, compare them by identity
+	require.True(t, slow.SubConn != fast.SubConn)
+	fast.Done(balancer.DoneInfo{})
+
+	for i := 0; i < 5; i++ {
+		gotPick, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.True(t, fast.SubConn == gotPick.SubConn)
+		gotPick.Done(balancer.DoneInfo{})
+	}
+	require.True(t, fast.SubConn == subConns[1] || fast.SubConn == subConns[2])
+}
+
 // double chceck the balancer import
 func setupTest() (*loadbalance.Picker, []*subConn) {
 	var subConns []*subConn
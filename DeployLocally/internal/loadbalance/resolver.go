@@ -3,18 +3,34 @@ package loadbalance
 import (
 	"context"
 	"fmt"
+	"net"
 	"sync"
 
+	"github.com/hashicorp/serf/coordinate"
 	api "github.com/madalosso/proglog/api/v1"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
 )
 
+// Resolver discovers the cluster's servers. Register a Resolver with the
+// client's zone through grpc.WithResolvers so the picker prefers servers in
+// that zone.
+//
+// The client is assumed to be close to the server it resolves through, so
+// that server's network coordinate estimates the round trip time to the
+// others. That's the server the target names, or the one the name resolved
+// to. Resolving through a load balancer the resolver can't tell which
+// server answered, it leaves the estimates out and the picker goes by the
+// latencies it measures instead.
 type Resolver struct {
+	Zone string
+
 	mu            sync.Mutex
+	target        string
 	clientConn    resolver.ClientConn
 	resolverConn  *grpc.ClientConn
 	serviceConfig *serviceconfig.ParseResult
@@ -25,8 +41,14 @@ var _ resolver.Builder = (*Resolver)(nil)
 
 func (r *Resolver) Build(target resolver.Target,
 	cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	r.logger = zap.L().Named("resolver")
-	r.clientConn = cc
+	// the registered Resolver only builds, each connection gets its own to
+	// resolve with
+	res := &Resolver{
+		Zone:       r.Zone,
+		target:     target.Endpoint,
+		clientConn: cc,
+		logger:     zap.L().Named("resolver"),
+	}
 	var dialOpts []grpc.DialOption
 	if opts.DialCreds != nil {
 		dialOpts = append(
@@ -35,17 +57,17 @@ func (r *Resolver) Build(target resolver.Target,
 		)
 	}
 
-	r.serviceConfig = r.clientConn.ParseServiceConfig(
+	res.serviceConfig = res.clientConn.ParseServiceConfig(
 		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
 	)
 	var err error
-	r.resolverConn, err = grpc.Dial(target.Endpoint, dialOpts...)
+	res.resolverConn, err = grpc.Dial(target.Endpoint, dialOpts...)
 	if err != nil {
 		return nil, err
 	}
-	r.ResolveNow(resolver.ResolveNowOptions{})
+	res.ResolveNow(resolver.ResolveNowOptions{})
 
-	return r, nil
+	return res, nil
 }
 
 const Name = "proglog"
@@ -60,22 +82,47 @@ func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
 	client := api.NewLogClient(r.resolverConn)
 	// get cluster and then set on cc attributes
 	ctx := context.Background()
-	res, err := client.GetServers(ctx, &api.GetServersRequest{})
+	var answered peer.Peer
+	res, err := client.GetServers(ctx, &api.GetServersRequest{}, grpc.Peer(&answered))
 	if err != nil {
 		r.logger.Error(
 			"failed to resolve server",
 			zap.Error(err),
 		)
+		// gRPC polls ResolveNow with backoff until resolving succeeds
+		r.clientConn.ReportError(err)
 		return
 	}
+	var near *coordinate.Coordinate
+	if server := r.answeredBy(res.Servers, answered.Addr); server == nil {
+		r.logger.Debug(
+			"no round trip time estimates, the server resolved through isn't known",
+			zap.String("target", r.target),
+		)
+	} else if server.Coordinate != nil {
+		near = toCoordinate(server.Coordinate)
+	}
 	var addrs []resolver.Address
 	for _, server := range res.Servers {
+		kvs := []interface{}{
+			"is_leader",
+			server.IsLeader,
+			"role",
+			server.Role,
+			"zone",
+			server.Zone,
+			"client_zone",
+			r.Zone,
+		}
+		if near != nil && server.Coordinate != nil {
+			coord := toCoordinate(server.Coordinate)
+			if near.IsCompatibleWith(coord) {
+				kvs = append(kvs, "rtt", near.DistanceTo(coord))
+			}
+		}
 		addrs = append(addrs, resolver.Address{
-			Addr: server.RpcAddr,
-			Attributes: attributes.New(
-				"is_leader",
-				server.IsLeader,
-			),
+			Addr:       server.RpcAddr,
+			Attributes: attributes.New(kvs...),
 		})
 	}
 	r.clientConn.UpdateState(resolver.State{
@@ -84,6 +131,52 @@ func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
 	})
 }
 
+// answeredBy finds the server that answered from the target it was dialed
+// by or the address it was reached at.
+func (r *Resolver) answeredBy(servers []*api.Server, addr net.Addr) *api.Server {
+	for _, server := range servers {
+		if server.RpcAddr == r.target {
+			return server
+		}
+	}
+	if addr == nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	for _, server := range servers {
+		serverHost, serverPort, err := net.SplitHostPort(server.RpcAddr)
+		if err != nil || serverPort != port {
+			continue
+		}
+		if serverHost == host {
+			return server
+		}
+		// servers advertising names, like a StatefulSet's pods
+		ips, err := net.LookupHost(serverHost)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip == host {
+				return server
+			}
+		}
+	}
+	return nil
+}
+
+func toCoordinate(c *api.Coordinate) *coordinate.Coordinate {
+	return &coordinate.Coordinate{
+		Vec:        c.Vec,
+		Error:      c.Error,
+		Adjustment: c.Adjustment,
+		Height:     c.Height,
+	}
+}
+
 func (r *Resolver) Close() {
 	if err := r.resolverConn.Close(); err != nil {
 		r.logger.Error(
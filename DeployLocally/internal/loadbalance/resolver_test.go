@@ -3,6 +3,7 @@ package loadbalance_test
 import (
 	"net"
 	"testing"
+	"time"
 
 	api "github.com/madalosso/proglog/api/v1"
 
@@ -33,7 +34,7 @@ func TestResolver(t *testing.T) {
 	serverCreds := credentials.NewTLS(tlsConfig)
 
 	srv, err := server.NewGRPCServer(&server.Config{
-		GetServerer: &getServers{},
+		GetServerer: &getServers{addr: l.Addr().String()},
 	}, grpc.Creds(serverCreds))
 	require.NoError(t, err)
 	go srv.Serve(l)
@@ -51,8 +52,8 @@ func TestResolver(t *testing.T) {
 	opts := resolver.BuildOptions{
 		DialCreds: clientCreds,
 	}
-	r := &loadbalance.Resolver{}
-	_, err = r.Build(
+	builder := &loadbalance.Resolver{Zone: "a"}
+	r, err := builder.Build(
 		resolver.Target{
 			Endpoint: l.Addr().String(),
 		},
@@ -63,12 +64,25 @@ func TestResolver(t *testing.T) {
 
 	wantState := resolver.State{
 		Addresses: []resolver.Address{{
-			Addr:       "localhost:9001",
-			Attributes: attributes.New("is_leader", true),
+			Addr: l.Addr().String(),
+			Attributes: attributes.New(
+				"is_leader", true,
+				"role", api.RoleVoter,
+				"zone", "a",
+				"client_zone", "a",
+				"rtt", time.Duration(0),
+			),
 		}, {
 
-			Addr:       "localhost:9002",
-			Attributes: attributes.New("is_leader", false),
+			Addr: "localhost:9002",
+			Attributes: attributes.New(
+				"is_leader", false,
+				"role", api.RoleNonvoter,
+				"zone", "b",
+				"client_zone", "a",
+				// estimated from the server the client resolved through
+				"rtt", 5*time.Millisecond,
+			),
 		}},
 	}
 	require.Equal(t, wantState, conn.state)
@@ -78,18 +92,86 @@ func TestResolver(t *testing.T) {
 	require.Equal(t, wantState, conn.state)
 }
 
-type getServers struct{}
+func TestResolverThroughName(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	getServers := &getServers{addr: l.Addr().String()}
+	srv, err := server.NewGRPCServer(&server.Config{
+		GetServerer: getServers,
+	}, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	require.NoError(t, err)
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+
+	tlsConfig, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	opts := resolver.BuildOptions{
+		DialCreds: credentials.NewTLS(tlsConfig),
+	}
+	resolve := func() resolver.State {
+		conn := &clientConn{}
+		r, err := (&loadbalance.Resolver{}).Build(
+			resolver.Target{Endpoint: net.JoinHostPort("localhost", port)},
+			conn,
+			opts,
+		)
+		require.NoError(t, err)
+		r.Close()
+		return conn.state
+	}
+
+	// the name resolves to the server that answered, its coordinate counts
+	state := resolve()
+	require.Len(t, state.Addresses, 2)
+	require.Equal(t, 5*time.Millisecond, state.Addresses[1].Attributes.Value("rtt"))
+
+	// none of the servers is the one that answered, like behind a load
+	// balancer, so there are no estimates
+	getServers.addr = "192.0.2.1:" + port
+	state = resolve()
+	require.Len(t, state.Addresses, 2)
+	for _, addr := range state.Addresses {
+		require.Nil(t, addr.Attributes.Value("rtt"))
+	}
+}
+
+type getServers struct {
+	addr string
+}
 
 func (s *getServers) GetServers() ([]*api.Server, error) {
 	return []*api.Server{
 		{
-			Id:       "leader",
-			RpcAddr:  "localhost:9001",
-			IsLeader: true,
+			Id:         "leader",
+			RpcAddr:    s.addr,
+			IsLeader:   true,
+			Role:       api.RoleVoter,
+			Zone:       "a",
+			Coordinate: &api.Coordinate{Vec: []float64{0, 0}},
 		},
 		{
-			Id:      "follower",
-			RpcAddr: "localhost:9002",
+			Id:         "follower",
+			RpcAddr:    "localhost:9002",
+			Role:       api.RoleNonvoter,
+			Zone:       "b",
+			Coordinate: &api.Coordinate{Vec: []float64{0.003, 0.004}},
 		},
 	}, nil
 }
@@ -0,0 +1,243 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+)
+
+// autopilot runs on every node but only acts while the node is leader. It
+// tracks the health of the other servers from the raft traffic, promotes
+// non-voters that want to vote once they've been healthy for long enough and
+// removes servers it hasn't heard from for too long.
+type autopilot struct {
+	config    Config
+	raft      *raft.Raft
+	transport *trackingTransport
+	logger    *zap.Logger
+
+	mu sync.Mutex
+	// candidates are non-voters waiting to be promoted, read replicas that
+	// joined as non-voters on purpose never are.
+	candidates map[raft.ServerID]bool
+	// firstSeen stands in for the last contact of servers this leader
+	// hasn't heard from yet.
+	firstSeen    map[raft.ServerID]time.Time
+	healthySince map[raft.ServerID]time.Time
+	// leading is whether the last step ran as leader.
+	leading bool
+
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	doneCh       chan struct{}
+}
+
+func newAutopilot(config Config, r *raft.Raft, transport *trackingTransport) *autopilot {
+	return &autopilot{
+		config:       config.autopilotConfig(),
+		raft:         r,
+		transport:    transport,
+		logger:       zap.L().Named("autopilot"),
+		candidates:   make(map[raft.ServerID]bool),
+		firstSeen:    make(map[raft.ServerID]time.Time),
+		healthySince: make(map[raft.ServerID]time.Time),
+		shutdownCh:   make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+}
+
+func (a *autopilot) run() {
+	defer close(a.doneCh)
+	ticker := time.NewTicker(a.config.Autopilot.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.shutdownCh:
+			return
+		case <-ticker.C:
+			if err := a.step(time.Now()); err != nil {
+				a.logger.Error("failed to manage servers", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (a *autopilot) stop() {
+	a.shutdownOnce.Do(func() {
+		close(a.shutdownCh)
+	})
+	<-a.doneCh
+}
+
+// promoteWhenStable marks the non-voter to be promoted once it's healthy.
+func (a *autopilot) promoteWhenStable(id raft.ServerID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.candidates[id] = true
+}
+
+// step removes the dead servers and promotes the stable ones. The raft
+// changes are made without holding the lock, as they block until committed.
+func (a *autopilot) step(now time.Time) error {
+	if a.raft.State() != raft.Leader {
+		// what we tracked is stale by the time we lead again
+		a.mu.Lock()
+		a.firstSeen = make(map[raft.ServerID]time.Time)
+		a.healthySince = make(map[raft.ServerID]time.Time)
+		a.leading = false
+		a.mu.Unlock()
+		return nil
+	}
+
+	future := a.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	servers := future.Configuration().Servers
+	a.takeOver(servers)
+	remove, promote := a.plan(now, a.raft.LastIndex(), servers)
+	for _, server := range remove {
+		a.logger.Info("removing dead server", zap.String("id", string(server.ID)))
+		if err := a.raft.RemoveServer(server.ID, 0, 0).Error(); err != nil {
+			return err
+		}
+	}
+	for _, server := range promote {
+		a.logger.Info("promoting stable server", zap.String("id", string(server.ID)))
+		if err := a.raft.AddVoter(server.ID, server.Address, 0, 0).Error(); err != nil {
+			return err
+		}
+		a.mu.Lock()
+		delete(a.candidates, server.ID)
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// takeOver rebuilds the candidates from the configuration's non-voters on
+// the first step as leader, the previous leader's were only in its memory.
+func (a *autopilot) takeOver(servers []raft.Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.leading {
+		return
+	}
+	a.leading = true
+	if a.config.Autopilot.Candidate == nil {
+		return
+	}
+	for _, server := range servers {
+		if server.Suffrage == raft.Nonvoter && a.config.Autopilot.Candidate(server.ID) {
+			a.candidates[server.ID] = true
+		}
+	}
+}
+
+// plan tracks the servers' health and picks the dead servers to remove and
+// the stable candidates to promote. Like Consul's autopilot, it only removes
+// dead voters while the cluster can tolerate losing them all: a network blip
+// cutting the leader off from most voters must not shrink the voter set to
+// the few it can still reach.
+func (a *autopilot) plan(now time.Time, lastIndex uint64, servers []raft.Server) (remove, promote []raft.Server) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	voters := 0
+	for _, server := range servers {
+		if server.Suffrage == raft.Voter {
+			voters++
+		}
+	}
+	known := make(map[raft.ServerID]bool)
+	var dead, stable []raft.Server
+	deadVoters := 0
+
+	for _, server := range servers {
+		known[server.ID] = true
+		if server.ID == a.config.Raft.LocalID {
+			continue
+		}
+
+		if _, ok := a.firstSeen[server.ID]; !ok {
+			a.firstSeen[server.ID] = now
+		}
+		lastContact := a.transport.lastContact(server.ID)
+		if lastContact.Before(a.firstSeen[server.ID]) {
+			lastContact = a.firstSeen[server.ID]
+		}
+		if now.Sub(lastContact) > a.config.Autopilot.DeadServerThreshold {
+			dead = append(dead, server)
+			if server.Suffrage == raft.Voter {
+				deadVoters++
+			}
+			continue
+		}
+
+		var lag uint64
+		if match := a.transport.match(server.ID); match < lastIndex {
+			lag = lastIndex - match
+		}
+		healthy := now.Sub(lastContact) <= a.config.Autopilot.LastContactThreshold &&
+			lag <= a.config.Autopilot.MaxTrailingLogs
+		if !healthy {
+			delete(a.healthySince, server.ID)
+			continue
+		}
+		if _, ok := a.healthySince[server.ID]; !ok {
+			a.healthySince[server.ID] = now
+		}
+
+		if server.Suffrage != raft.Nonvoter || !a.candidates[server.ID] {
+			continue
+		}
+		if now.Sub(a.healthySince[server.ID]) < a.config.Autopilot.StabilizationTime {
+			continue
+		}
+		stable = append(stable, server)
+	}
+
+	tolerable := deadVoters <= (voters-1)/2
+	if !tolerable {
+		a.logger.Warn(
+			"not removing dead voters, the rest would lose their quorum",
+			zap.Int("dead", deadVoters),
+			zap.Int("voters", voters),
+		)
+	}
+	for _, server := range dead {
+		if server.Suffrage == raft.Voter {
+			if !tolerable {
+				continue
+			}
+			voters--
+		}
+		remove = append(remove, server)
+	}
+	for _, server := range stable {
+		if max := a.config.Autopilot.MaxVoters; max != 0 && voters >= max {
+			break
+		}
+		promote = append(promote, server)
+		voters++
+	}
+
+	// forget servers that left the configuration
+	for id := range a.firstSeen {
+		if !known[id] {
+			delete(a.firstSeen, id)
+		}
+	}
+	for id := range a.healthySince {
+		if !known[id] {
+			delete(a.healthySince, id)
+		}
+	}
+	for id := range a.candidates {
+		if !known[id] {
+			delete(a.candidates, id)
+		}
+	}
+	return remove, promote
+}
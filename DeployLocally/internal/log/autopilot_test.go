@@ -0,0 +1,52 @@
+package log
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutopilotKeepsQuorum(t *testing.T) {
+	for scenario, tc := range map[string]struct {
+		voters, dead int
+		removed      int
+	}{
+		"one of three voters dead":  {voters: 3, dead: 1, removed: 1},
+		"two of three voters dead":  {voters: 3, dead: 2, removed: 0},
+		"two of five voters dead":   {voters: 5, dead: 2, removed: 2},
+		"three of five voters dead": {voters: 5, dead: 3, removed: 0},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			config := Config{}
+			config.Raft.LocalID = "0"
+			config.Autopilot.DeadServerThreshold = time.Second
+			transport := newTrackingTransport(nil)
+			a := newAutopilot(config, nil, transport)
+
+			start := time.Now()
+			var servers []raft.Server
+			for i := 0; i < tc.voters; i++ {
+				id := raft.ServerID(fmt.Sprintf("%d", i))
+				servers = append(servers, raft.Server{ID: id, Suffrage: raft.Voter})
+			}
+			// a dead non-voter is removed however many voters are dead
+			servers = append(servers, raft.Server{ID: "replica", Suffrage: raft.Nonvoter})
+			remove, _ := a.plan(start, 0, servers)
+			require.Empty(t, remove)
+
+			now := start.Add(2 * time.Second)
+			for i := tc.dead + 1; i < tc.voters; i++ {
+				transport.contacts[raft.ServerID(fmt.Sprintf("%d", i))] = now
+			}
+			remove, _ = a.plan(now, 0, servers)
+			require.Equal(t, tc.removed+1, len(remove))
+			for _, server := range remove[:tc.removed] {
+				require.Equal(t, raft.Voter, server.Suffrage)
+			}
+			require.Equal(t, raft.ServerID("replica"), remove[len(remove)-1].ID)
+		})
+	}
+}
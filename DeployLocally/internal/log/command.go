@@ -0,0 +1,39 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Command applies one type of command to the application's replicated state.
+// Every server applies the commands in the same order, so Apply must be
+// deterministic.
+type Command interface {
+	// Apply applies the marshaled request. What it returns, errors
+	// included, is what DistributedLog.Apply returns on the server that
+	// proposed the command.
+	Apply(req []byte) interface{}
+}
+
+// Snapshotter is implemented by commands whose state must be in the raft
+// snapshots, since the log entries that built it get compacted away.
+type Snapshotter interface {
+	// Snapshot returns the command's state. It's called on raft's FSM
+	// goroutine, so it must copy the state rather than hold on to it.
+	Snapshot() ([]byte, error)
+	// Restore replaces the command's state with the snapshotted one.
+	Restore(state []byte) error
+}
+
+var ErrUnknownRequestType = errors.New("unknown request type")
+
+// Apply replicates the request through raft and applies it with the command
+// registered for its type.
+func (l *DistributedLog) Apply(reqType RequestType, req proto.Message) (interface{}, error) {
+	if _, ok := l.config.Commands[reqType]; !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownRequestType, reqType)
+	}
+	return l.apply(reqType, req)
+}
@@ -1,6 +1,11 @@
 package log
 
-import "github.com/hashicorp/raft"
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
 
 type Config struct {
 	Raft struct {
@@ -8,10 +13,171 @@ type Config struct {
 		BindAddr    string
 		StreamLayer *StreamLayer
 		Bootstrap   bool
+		// TransportMaxPool is how many connections are pooled per peer.
+		TransportMaxPool int
+		// TransportTimeout bounds the I/O with peers.
+		TransportTimeout time.Duration
+		// ApplyTimeout bounds how long an append waits for raft to take it.
+		ApplyTimeout time.Duration
+		// AppendBatchSize caps how many concurrent appends share a raft
+		// entry, 1 gives every append its own entry.
+		AppendBatchSize int
 	}
 	Segment struct {
 		MaxStoreBytes uint64
 		MaxIndexBytes uint64
 		InitialOffset uint64
 	}
+	// Autopilot lets the leader manage membership: new voters join as
+	// non-voters and get promoted once stable, dead servers get removed.
+	Autopilot struct {
+		Enabled bool
+		// DeadServerThreshold is how long a server can go without contact
+		// before it's removed.
+		DeadServerThreshold time.Duration
+		// StabilizationTime is how long a server must stay healthy before
+		// it's promoted to voter.
+		StabilizationTime time.Duration
+		// LastContactThreshold and MaxTrailingLogs bound how stale and how
+		// far behind a healthy server can be.
+		LastContactThreshold time.Duration
+		MaxTrailingLogs      uint64
+		// MaxVoters caps the voters, it must be odd. 0 means no cap.
+		MaxVoters int
+		// Interval is how often the leader checks the servers.
+		Interval time.Duration
+		// Candidate reports whether the non-voter wants to vote. Only the
+		// leader that joined a server knows it's a candidate, so a new
+		// leader asks Candidate about the configuration's non-voters. When
+		// nil, a new leader only promotes the servers that join again.
+		Candidate func(id raft.ServerID) bool
+	}
+	// Commands registers the application's own commands, replicated through
+	// raft next to the log's appends. There's no registering them once the
+	// log is built: raft restores the snapshot and replays the log as it
+	// starts, and an entry applied before its command was registered is
+	// rejected on this server only, so its state would diverge from the
+	// others'. Fixed at construction, the FSM also reads them without locks.
+	Commands map[RequestType]Command
+}
+
+const (
+	defaultTransportMaxPool = 5
+	defaultTransportTimeout = 10 * time.Second
+	defaultApplyTimeout     = 10 * time.Second
+	defaultAppendBatchSize  = 64
+
+	defaultDeadServerThreshold = 5 * time.Minute
+	defaultStabilizationTime   = 10 * time.Second
+	defaultMaxTrailingLogs     = 250
+	defaultAutopilotInterval   = time.Second
+)
+
+// raftConfig returns raft's defaults overridden by the configured timeouts.
+func (c Config) raftConfig() *raft.Config {
+	config := raft.DefaultConfig()
+	config.LocalID = c.Raft.LocalID
+	if c.Raft.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = c.Raft.HeartbeatTimeout
+	}
+	if c.Raft.ElectionTimeout != 0 {
+		config.ElectionTimeout = c.Raft.ElectionTimeout
+	}
+	if c.Raft.LeaderLeaseTimeout != 0 {
+		config.LeaderLeaseTimeout = c.Raft.LeaderLeaseTimeout
+	}
+	if c.Raft.CommitTimeout != 0 {
+		config.CommitTimeout = c.Raft.CommitTimeout
+	}
+	if c.Raft.MaxAppendEntries != 0 {
+		config.MaxAppendEntries = c.Raft.MaxAppendEntries
+	}
+	config.BatchApplyCh = c.Raft.BatchApplyCh
+	return config
+}
+
+func (c Config) transportMaxPool() int {
+	if c.Raft.TransportMaxPool == 0 {
+		return defaultTransportMaxPool
+	}
+	return c.Raft.TransportMaxPool
+}
+
+func (c Config) transportTimeout() time.Duration {
+	if c.Raft.TransportTimeout == 0 {
+		return defaultTransportTimeout
+	}
+	return c.Raft.TransportTimeout
+}
+
+func (c Config) appendBatchSize() int {
+	if c.Raft.AppendBatchSize == 0 {
+		return defaultAppendBatchSize
+	}
+	return c.Raft.AppendBatchSize
+}
+
+func (c Config) applyTimeout() time.Duration {
+	if c.Raft.ApplyTimeout == 0 {
+		return defaultApplyTimeout
+	}
+	return c.Raft.ApplyTimeout
+}
+
+// autopilotConfig returns the autopilot settings with defaults filled in.
+func (c Config) autopilotConfig() Config {
+	if c.Autopilot.DeadServerThreshold == 0 {
+		c.Autopilot.DeadServerThreshold = defaultDeadServerThreshold
+	}
+	if c.Autopilot.StabilizationTime == 0 {
+		c.Autopilot.StabilizationTime = defaultStabilizationTime
+	}
+	if c.Autopilot.LastContactThreshold == 0 {
+		c.Autopilot.LastContactThreshold = c.raftConfig().HeartbeatTimeout
+	}
+	if c.Autopilot.MaxTrailingLogs == 0 {
+		c.Autopilot.MaxTrailingLogs = defaultMaxTrailingLogs
+	}
+	if c.Autopilot.Interval == 0 {
+		c.Autopilot.Interval = defaultAutopilotInterval
+	}
+	return c
+}
+
+// validate catches invalid raft settings, including combinations of timeouts
+// that are only invalid once merged with raft's defaults.
+func (c Config) validate() error {
+	if c.Raft.TransportMaxPool < 0 {
+		return fmt.Errorf("raft transport max pool can't be negative")
+	}
+	if c.Raft.TransportTimeout < 0 {
+		return fmt.Errorf("raft transport timeout can't be negative")
+	}
+	if c.Raft.ApplyTimeout < 0 {
+		return fmt.Errorf("raft apply timeout can't be negative")
+	}
+	if c.Raft.AppendBatchSize < 0 {
+		return fmt.Errorf("raft append batch size can't be negative")
+	}
+	for reqType, cmd := range c.Commands {
+		if reqType == AppendRequestType {
+			return fmt.Errorf("request type %d is reserved for appends", reqType)
+		}
+		if cmd == nil {
+			return fmt.Errorf("command for request type %d is nil", reqType)
+		}
+	}
+	if max := c.Autopilot.MaxVoters; max < 0 || (max != 0 && max%2 == 0) {
+		return fmt.Errorf("autopilot max voters must be odd")
+	}
+	if c.Autopilot.DeadServerThreshold < 0 ||
+		c.Autopilot.StabilizationTime < 0 ||
+		c.Autopilot.LastContactThreshold < 0 ||
+		c.Autopilot.Interval < 0 {
+		return fmt.Errorf("autopilot durations can't be negative")
+	}
+	if err := raft.ValidateConfig(c.raftConfig()); err != nil {
+		return fmt.Errorf("invalid raft config: %w", err)
+	}
+	return nil
 }
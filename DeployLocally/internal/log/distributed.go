@@ -1,29 +1,120 @@
 package log
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	api "github.com/madalosso/proglog/api/v1"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// DistributedLog replicates the log with raft. The committed entries of
+// raft's log are the records, so each record is only written once.
 type DistributedLog struct {
-	config Config
-	log    *Log
-	raft   *raft.Raft
+	config        Config
+	fsm           *fsm
+	raft          *raft.Raft
+	transport     *trackingTransport
+	logStore      *logStore
+	stableStore   *raftboltdb.BoltStore
+	snapshotStore *raft.FileSnapshotStore
+	autopilot     *autopilot
+
+	// decoded holds the records of the entries read last, consumers read
+	// an entry's records one after the other.
+	decoded *entryCache
+
+	// applies are read locked while in flight so a leadership transfer can
+	// drain them and hold off new ones until it's done.
+	applies sync.RWMutex
+
+	// appends queues the appends for the workers batching them into raft
+	// entries.
+	appends      chan *pendingAppend
+	shutdownCh   chan struct{}
+	shutdownOnce sync.Once
+	workers      sync.WaitGroup
+
+	// stored holds, by the id in their entry's extensions, what to do once
+	// the leader stored the entry, to answer the appends with leader acks.
+	// The ids follow the random entryNonce, which the other nodes' and
+	// this node's previous runs' entries don't.
+	storedMu   sync.Mutex
+	stored     map[uint64]func()
+	entryNonce uint64
+	lastEntry  uint64
 }
 
+type pendingAppend struct {
+	records  []*api.Record
+	acks     api.Acks
+	offset   uint64
+	achieved api.Acks
+	err      error
+	// handed is closed once raft has the append's entry, raft keeps the
+	// entries in the order it got them.
+	handed chan struct{}
+	done   chan struct{}
+}
+
+// appendWorkers is how many raft entries of appends can be in flight at once.
+// While they are, the appends queue up to share the next entries.
+const appendWorkers = 8
+
+// fsm tracks which raft log entries are records. Records are numbered from
+// 0 as they're applied, the raft indexes also count raft's own entries and
+// the commands' ones.
 type fsm struct {
-	log *Log
+	commands map[RequestType]Command
+
+	mu sync.RWMutex
+	// runs of consecutive raft entries holding as many records each, a new
+	// run starts past each entry that isn't an append. The records up to
+	// the last applied one are the ones the log can read.
+	runs       []run
+	nextOffset uint64
+
+	// legacy holds the records of the snapshots taken before the records
+	// were kept in raft's log, which held the records themselves: nodes
+	// that compacted raft's log since no longer have their entries. They
+	// come before the runs' records, and are kept under legacyDir, where
+	// those nodes kept their copy of the records.
+	legacy       *Log
+	legacyDir    string
+	legacyLowest uint64
+
+	// entries is raft's log, snapshots copy the runs' records from it when
+	// followers can't replicate them.
+	entries *logStore
+
+	notifier appendNotifier
+}
+
+type run struct {
+	offset uint64
+	index  uint64
+	size   uint64
+}
+
+func newFSM(commands map[RequestType]Command) *fsm {
+	return &fsm{commands: commands}
 }
 
 type RequestType uint8
@@ -33,46 +124,105 @@ const (
 )
 
 func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
 	l := &DistributedLog{
-		config: config,
+		config:     config,
+		fsm:        newFSM(config.Commands),
+		decoded:    newEntryCache(entryCacheSize),
+		appends:    make(chan *pendingAppend),
+		shutdownCh: make(chan struct{}),
+		stored:     make(map[uint64]func()),
 	}
-	if err := l.setupLog(dataDir); err != nil {
+	// raft restores the legacy records from the snapshot as it starts, they
+	// replace the ones there, or the copy of the records nodes kept before,
+	// once the snapshot's are all read back
+	l.fsm.legacyDir = filepath.Join(dataDir, "log")
+	if err := binary.Read(rand.Reader, enc, &l.entryNonce); err != nil {
 		return nil, err
 	}
-
 	if err := l.setupRaft(dataDir); err != nil {
 		return nil, err
 	}
 
+	if config.Autopilot.Enabled {
+		l.autopilot = newAutopilot(config, l.raft, l.transport)
+		go l.autopilot.run()
+	}
+
+	l.workers.Add(appendWorkers)
+	for i := 0; i < appendWorkers; i++ {
+		go l.appendWorker()
+	}
+
 	return l, nil
 }
 
-func (l *DistributedLog) setupLog(dataDir string) error {
-	logDir := filepath.Join(dataDir, "log")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+func (l *DistributedLog) setupRaft(dataDir string) error {
+	logStore, stableStore, snapshotStore, err := newRaftStores(dataDir, l.config)
+	if err != nil {
+		return err
+	}
+	l.logStore = logStore
+	l.fsm.entries = logStore
+	l.stableStore = stableStore
+	l.snapshotStore = snapshotStore
+
+	l.transport = newTrackingTransport(raft.NewNetworkTransport(
+		l.config.Raft.StreamLayer,
+		l.config.transportMaxPool(),
+		l.config.transportTimeout(),
+		os.Stderr,
+	))
+
+	config := l.config.raftConfig()
+
+	l.raft, err = raft.NewRaft(
+		config, l.fsm, logStore, stableStore, snapshotStore, l.transport,
+	)
+	if err != nil {
+		return err
+	}
+	logStore.applied = l.raft.AppliedIndex
+	logStore.onStore = l.onStore
+
+	hasState, err := raft.HasExistingState(
+		logStore, stableStore, snapshotStore,
+	)
+	if err != nil {
 		return err
 	}
-	var err error
-	l.log, err = NewLog(logDir, l.config)
+	if l.config.Raft.Bootstrap && !hasState {
+		config := raft.Configuration{
+			Servers: []raft.Server{
+				{ID: config.LocalID, Address: raft.ServerAddress(l.config.Raft.BindAddr)},
+			},
+		}
+		err = l.raft.BootstrapCluster(config).Error()
+	}
 	return err
 }
 
-func (l *DistributedLog) setupRaft(dataDir string) error {
-	// finite state machine
-	fsm := &fsm{log: l.log}
+func newRaftStores(dataDir string, c Config) (
+	*logStore,
+	*raftboltdb.BoltStore,
+	*raft.FileSnapshotStore,
+	error,
+) {
 	logDir := filepath.Join(dataDir, "raft", "log")
 	if err := os.MkdirAll(logDir, 0755); err != nil {
-		return err
+		return nil, nil, nil, err
 	}
-	logConfig := l.config
+	logConfig := c
 	logConfig.Segment.InitialOffset = 1
 	logStore, err := newLogStore(logDir, logConfig)
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	stableStore, err := raftboltdb.NewBoltStore(filepath.Join(dataDir, "raft", "stable"))
 	if err != nil {
-		return err
+		return nil, nil, nil, err
 	}
 	retain := 1
 	snapshotStore, err := raft.NewFileSnapshotStore(
@@ -80,72 +230,257 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 		retain,
 		os.Stderr,
 	)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return logStore, stableStore, snapshotStore, nil
+}
+
+// RecoverCluster rewrites the raft configuration stored under dataDir to the
+// given servers, so the survivors of a lost quorum can elect a leader again.
+// The node must be stopped, and every survivor must recover with the same
+// servers before any of them is started.
+func RecoverCluster(dataDir string, config Config, servers []raft.Server) error {
+	logStore, stableStore, snapshotStore, err := newRaftStores(dataDir, config)
 	if err != nil {
 		return err
 	}
+	defer logStore.Close()
+	defer stableStore.Close()
 
-	maxPool := 5
-	timeout := 10 * time.Second
-	transport := raft.NewNetworkTransport(
-		l.config.Raft.StreamLayer,
-		maxPool,
-		timeout,
-		os.Stderr,
+	// raft applies every entry it has to snapshot the FSM, so they're all
+	// committed records from now on and must survive the compaction after.
+	logStore.applied = func() uint64 { return math.MaxUint64 }
+
+	// the legacy records restored for the snapshot are thrown away after,
+	// the node restores them from that snapshot when it starts
+	fsm := newFSM(config.Commands)
+	fsm.entries = logStore
+	fsm.legacyDir = filepath.Join(dataDir, "recover")
+	defer os.RemoveAll(fsm.legacyDir)
+	defer fsm.close()
+
+	_, transport := raft.NewInmemTransport("")
+	return raft.RecoverCluster(
+		config.raftConfig(),
+		fsm,
+		logStore,
+		stableStore,
+		snapshotStore,
+		transport,
+		raft.Configuration{Servers: servers},
 	)
+}
+
+// HasState reports whether the node already has raft state on disk, either
+// from a bootstrap or from replicating a cluster's log.
+func (l *DistributedLog) HasState() (bool, error) {
+	return raft.HasExistingState(l.logStore, l.stableStore, l.snapshotStore)
+}
 
-	config := raft.DefaultConfig()
-	config.LocalID = l.config.Raft.LocalID
-	if l.config.Raft.HeartbeatTimeout != 0 {
-		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
+// Bootstrap starts a new cluster with the given servers as voters. Nodes with
+// existing state refuse to bootstrap so they can't split an existing cluster.
+func (l *DistributedLog) Bootstrap(servers []*api.Server) error {
+	hasState, err := l.HasState()
+	if err != nil {
+		return err
 	}
-	if l.config.Raft.ElectionTimeout != 0 {
-		config.ElectionTimeout = l.config.Raft.ElectionTimeout
+	if hasState {
+		return fmt.Errorf("refusing to bootstrap a node with existing state")
 	}
-	if l.config.Raft.LeaderLeaseTimeout != 0 {
-		config.LeaderLeaseTimeout = l.config.Raft.LeaderLeaseTimeout
+	var config raft.Configuration
+	for _, server := range servers {
+		config.Servers = append(config.Servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(server.Id),
+			Address:  raft.ServerAddress(server.RpcAddr),
+		})
 	}
-	if l.config.Raft.CommitTimeout != 0 {
-		config.CommitTimeout = l.config.Raft.CommitTimeout
+	return l.raft.BootstrapCluster(config).Error()
+}
+
+// Append appends the record once it's committed. Concurrent appends share
+// raft entries, up to the configured batch size.
+func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
+	off, _, err := l.AppendAcks(record, api.Acks_ACKS_QUORUM)
+	return off, err
+}
+
+// AppendAcks appends the record and returns once it got as far as acks asks,
+// along with how far it got. Records only get their offset once committed,
+// so it's only returned once a quorum acked them.
+func (l *DistributedLog) AppendAcks(record *api.Record, acks api.Acks) (
+	uint64, api.Acks, error,
+) {
+	if record == nil {
+		record = &api.Record{}
 	}
+	return l.AppendRecords([]*api.Record{record}, acks)
+}
 
-	l.raft, err = raft.NewRaft(
-		config, fsm, logStore, stableStore, snapshotStore, transport,
-	)
-	if err != nil {
-		return err
+// AppendRecords appends the records at consecutive offsets, returning the
+// first one's, like AppendAcks.
+func (l *DistributedLog) AppendRecords(records []*api.Record, acks api.Acks) (
+	uint64, api.Acks, error,
+) {
+	// appends without acks would fail after the producer was answered
+	if l.raft.State() != raft.Leader {
+		return 0, acks, raft.ErrNotLeader
 	}
+	if len(records) == 0 {
+		return 0, acks, fmt.Errorf("no records to append")
+	}
+	for i, record := range records {
+		if record == nil {
+			records[i] = &api.Record{}
+		}
+	}
+	p := &pendingAppend{
+		records: records,
+		acks:    acks,
+		handed:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	select {
+	case l.appends <- p:
+	case <-l.shutdownCh:
+		return 0, acks, raft.ErrRaftShutdown
+	}
+	if acks == api.Acks_ACKS_NONE {
+		// the producer's next appends are handed to raft after this one
+		<-p.handed
+		return 0, acks, nil
+	}
+	<-p.done
+	return p.offset, p.achieved, p.err
+}
 
-	hasState, err := raft.HasExistingState(
-		logStore, stableStore, snapshotStore,
-	)
-	if err != nil {
-		return err
+func (l *DistributedLog) appendWorker() {
+	defer l.workers.Done()
+	max := l.config.appendBatchSize()
+	for {
+		var batch []*pendingAppend
+		select {
+		case p := <-l.appends:
+			batch = append(batch, p)
+		case <-l.shutdownCh:
+			return
+		}
+	collect:
+		for len(batch) < max {
+			select {
+			case p := <-l.appends:
+				batch = append(batch, p)
+			default:
+				break collect
+			}
+		}
+		l.appendBatch(batch)
 	}
-	if l.config.Raft.Bootstrap && !hasState {
-		config := raft.Configuration{
-			Servers: []raft.Server{
-				{ID: config.LocalID, Address: raft.ServerAddress(l.config.Raft.BindAddr)},
-			},
+}
+
+func (l *DistributedLog) appendBatch(batch []*pendingAppend) {
+	records := &api.Records{}
+	var leaderAcks []*pendingAppend
+	first := make([]uint64, len(batch))
+	for i, p := range batch {
+		first[i] = uint64(len(records.Records))
+		records.Records = append(records.Records, p.records...)
+		if p.acks == api.Acks_ACKS_LEADER {
+			leaderAcks = append(leaderAcks, p)
 		}
-		err = l.raft.BootstrapCluster(config).Error()
 	}
-	return err
+
+	var ext []byte
+	var id uint64
+	if len(leaderAcks) > 0 {
+		l.storedMu.Lock()
+		l.lastEntry++
+		id = l.lastEntry
+		l.stored[id] = func() {
+			for _, p := range leaderAcks {
+				p.achieved = api.Acks_ACKS_LEADER
+				close(p.done)
+			}
+		}
+		l.storedMu.Unlock()
+		ext = make([]byte, 16)
+		enc.PutUint64(ext, l.entryNonce)
+		enc.PutUint64(ext[8:], id)
+	}
+
+	handed := false
+	hand := func() {
+		if handed {
+			return
+		}
+		handed = true
+		for _, p := range batch {
+			close(p.handed)
+		}
+	}
+	res, err := l.applyLog(AppendRequestType, records, ext, hand)
+	hand()
+	produced, ok := res.(*api.ProduceResponse)
+	if err == nil && !ok {
+		err = fmt.Errorf("unexpected append response: %T", res)
+	}
+
+	answered := false
+	if ext != nil {
+		l.storedMu.Lock()
+		_, waiting := l.stored[id]
+		delete(l.stored, id)
+		l.storedMu.Unlock()
+		answered = !waiting
+	}
+	for i, p := range batch {
+		if p.acks == api.Acks_ACKS_LEADER && answered {
+			continue
+		}
+		if err != nil {
+			p.err = err
+		} else {
+			p.offset = produced.Offset + first[i]
+			p.achieved = api.Acks_ACKS_QUORUM
+		}
+		close(p.done)
+	}
 }
 
-func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
-	res, err := l.apply(
-		AppendRequestType,
-		&api.ProduceRequest{Record: record},
-	)
-	if err != nil {
-		return 0, err
+// onStore answers the appends with leader acks once the leader stored their
+// entry. Followers store the entries with their extensions too, the nonce
+// tells the entries this node proposed apart from the ones it replicates.
+func (l *DistributedLog) onStore(ext []byte) {
+	if len(ext) != 16 || enc.Uint64(ext) != l.entryNonce {
+		return
+	}
+	id := enc.Uint64(ext[8:])
+	l.storedMu.Lock()
+	fn, ok := l.stored[id]
+	delete(l.stored, id)
+	l.storedMu.Unlock()
+	if ok {
+		fn()
 	}
-	// TODO: study more type assertion
-	return res.(*api.ProduceResponse).Offset, nil
 }
 
-// CHECK: interface{}
+// apply applies the request through raft and returns the FSM's response.
 func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interface{}, error) {
+	return l.applyLog(reqType, req, nil, nil)
+}
+
+// applyLog applies the request with the extensions set on its log entry,
+// calling handed once raft has the entry, if given.
+func (l *DistributedLog) applyLog(
+	reqType RequestType,
+	req proto.Message,
+	ext []byte,
+	handed func(),
+) (interface{}, error) {
+	l.applies.RLock()
+	defer l.applies.RUnlock()
+
 	var buf bytes.Buffer
 
 	_, err := buf.Write([]byte{byte(reqType)})
@@ -162,14 +497,17 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 		return nil, err
 	}
 
-	timeout := 10 * time.Second
+	timeout := l.config.applyTimeout()
 
 	// This blocks execution (raft channels wait for internal processing)
 	// ApplyFuture is an interface with Response() and Error(),
 	// both types logFuture and errorFuture fulfill the interface so either
 	// of these types can be returned. We first check if Error is present,
 	// if not, then check Response
-	future := l.raft.Apply(buf.Bytes(), timeout)
+	future := l.raft.ApplyLog(raft.Log{Data: buf.Bytes(), Extensions: ext}, timeout)
+	if handed != nil {
+		handed()
+	}
 
 	// errors within raft context
 	if future.Error() != nil {
@@ -185,24 +523,150 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 	return res, nil
 }
 
+// Wait waits until the record at the offset is applied.
+func (l *DistributedLog) Wait(ctx context.Context, offset uint64) error {
+	return l.fsm.notifier.wait(ctx, offset, func() uint64 {
+		l.fsm.mu.RLock()
+		defer l.fsm.mu.RUnlock()
+		return l.fsm.nextOffset
+	})
+}
+
+// Read reads the record straight from the raft log entry that appended it.
 func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
-	return l.log.Read(offset)
+	if record, ok, err := l.fsm.readLegacy(offset); ok {
+		return record, err
+	}
+	index, pos, err := l.fsm.index(offset)
+	if err != nil {
+		return nil, err
+	}
+	records, err := l.entryRecords(index)
+	if err != nil {
+		return nil, err
+	}
+	if pos >= uint64(len(records)) {
+		return nil, fmt.Errorf("raft log entry %d has no record %d", index, pos)
+	}
+	// the cached records are shared with the other readers
+	record := proto.Clone(records[pos]).(*api.Record)
+	record.Offset = offset
+	return record, nil
+}
+
+// entryRecords returns the records of the raft entry at the index, decoding
+// the entry only when it isn't cached.
+func (l *DistributedLog) entryRecords(index uint64) ([]*api.Record, error) {
+	if records, ok := l.decoded.get(index); ok {
+		return records, nil
+	}
+	entry, err := l.logStore.Read(index)
+	if err != nil {
+		return nil, err
+	}
+	var records api.Records
+	if err := proto.Unmarshal(entry.Value[1:], &records); err != nil {
+		return nil, err
+	}
+	l.decoded.add(index, records.Records)
+	return records.Records, nil
+}
+
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	lowest, _ := l.fsm.offsets()
+	return lowest, nil
+}
+
+func (l *DistributedLog) HighestOffset() (uint64, error) {
+	_, next := l.fsm.offsets()
+	if next == 0 {
+		return 0, nil
+	}
+	return next - 1, nil
 }
 
-func (l *DistributedLog) Join(id, addr string) error {
+// GetLogInfo describes the records applied on this node. The segments are
+// those of raft's log, which holds the records.
+func (l *DistributedLog) GetLogInfo() (*api.GetLogInfoResponse, error) {
+	res, err := l.logStore.GetLogInfo()
+	if err != nil {
+		return nil, err
+	}
+	lowest, next := l.fsm.offsets()
+	res.LowestOffset = lowest
+	res.HighestOffset = 0
+	if next > 0 {
+		res.HighestOffset = next - 1
+	}
+	res.CommitIndex, err = strconv.ParseUint(l.raft.Stats()["commit_index"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// StartOffset resolves where consuming from the start position begins,
+// latest being right after the highest applied record.
+func (l *DistributedLog) StartOffset(start api.Start, offset uint64) (uint64, error) {
+	lowest, next := l.fsm.offsets()
+	return startOffset(start, offset, lowest, next)
+}
+
+// Join adds the server to the raft cluster. Servers with the nonvoter role
+// are added as read replicas, any other role joins as a voter. With autopilot
+// enabled, voters join as non-voters and get promoted once they're stable.
+func (l *DistributedLog) Join(id, addr, role string) error {
+	if role == api.RoleNonvoter {
+		return l.addServer(raft.ServerID(id), raft.ServerAddress(addr), raft.Nonvoter)
+	}
+	if l.autopilot == nil {
+		return l.addServer(raft.ServerID(id), raft.ServerAddress(addr), raft.Voter)
+	}
+
 	configFuture := l.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
 		return err
 	}
-
 	serverID := raft.ServerID(id)
 	serverAddr := raft.ServerAddress(addr)
+	for _, server := range configFuture.Configuration().Servers {
+		if server.ID == serverID && server.Address == serverAddr {
+			if server.Suffrage == raft.Nonvoter {
+				l.autopilot.promoteWhenStable(serverID)
+			}
+			return nil
+		}
+	}
+	if err := l.addServer(serverID, serverAddr, raft.Nonvoter); err != nil {
+		return err
+	}
+	l.autopilot.promoteWhenStable(serverID)
+	return nil
+}
+
+func (l *DistributedLog) addServer(
+	serverID raft.ServerID,
+	serverAddr raft.ServerAddress,
+	suffrage raft.ServerSuffrage,
+) error {
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
 
 	for _, server := range configFuture.Configuration().Servers {
 		if server.ID == serverID || server.Address == serverAddr {
 			if server.ID == serverID && server.Address == serverAddr {
-				// server already joined
-				return nil
+				if server.Suffrage == suffrage {
+					// server already joined
+					return nil
+				}
+				if suffrage == raft.Nonvoter {
+					demoteFuture := l.raft.DemoteVoter(serverID, 0, 0)
+					return demoteFuture.Error()
+				}
+				// AddVoter promotes the existing non-voter
+				break
 			}
 			// Remove the existing server
 			removeFuture := l.raft.RemoveServer(serverID, 0, 0)
@@ -211,7 +675,12 @@ func (l *DistributedLog) Join(id, addr string) error {
 			}
 		}
 	}
-	addFuture := l.raft.AddVoter(serverID, serverAddr, 0, 0)
+	var addFuture raft.IndexFuture
+	if suffrage == raft.Nonvoter {
+		addFuture = l.raft.AddNonvoter(serverID, serverAddr, 0, 0)
+	} else {
+		addFuture = l.raft.AddVoter(serverID, serverAddr, 0, 0)
+	}
 	if err := addFuture.Error(); err != nil {
 		return err
 	}
@@ -223,6 +692,112 @@ func (l *DistributedLog) Leave(id string) error {
 	return removeFuture.Error()
 }
 
+// Fail handles a server that stopped responding. Autopilot removes dead
+// servers itself once they've been gone long enough, without it the server
+// is removed right away like when it leaves.
+func (l *DistributedLog) Fail(id string) error {
+	if l.autopilot != nil {
+		return nil
+	}
+	return l.Leave(id)
+}
+
+// AddVoter adds the server as a voter, or promotes it, right away even with
+// autopilot enabled.
+func (l *DistributedLog) AddVoter(id, addr string) error {
+	return l.addServer(raft.ServerID(id), raft.ServerAddress(addr), raft.Voter)
+}
+
+func (l *DistributedLog) DemoteVoter(id string) error {
+	demoteFuture := l.raft.DemoteVoter(raft.ServerID(id), 0, 0)
+	return demoteFuture.Error()
+}
+
+// ListPeers describes the raft configuration as seen by this node. Match
+// indexes and lag are only known when this node is the leader.
+func (l *DistributedLog) ListPeers() (*api.ListPeersResponse, error) {
+	future := l.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+	state := l.raft.State()
+	lastIndex := l.raft.LastIndex()
+	res := &api.ListPeersResponse{
+		State:        state.String(),
+		LastIndex:    lastIndex,
+		AppliedIndex: l.raft.AppliedIndex(),
+	}
+	leader := l.raft.Leader()
+	for _, server := range future.Configuration().Servers {
+		peer := &api.Peer{
+			Id:       string(server.ID),
+			RpcAddr:  string(server.Address),
+			Role:     role(server.Suffrage),
+			IsLeader: leader == server.Address,
+		}
+		if state == raft.Leader {
+			peer.MatchIndex = lastIndex
+			if server.ID != l.config.Raft.LocalID {
+				peer.MatchIndex = l.transport.match(server.ID)
+			}
+			if peer.MatchIndex < lastIndex {
+				peer.Lag = lastIndex - peer.MatchIndex
+			}
+		}
+		res.Peers = append(res.Peers, peer)
+	}
+	return res, nil
+}
+
+func (l *DistributedLog) Stats() map[string]string {
+	return l.raft.Stats()
+}
+
+// TransferLeadership hands leadership over to the voter with the given id, or
+// to any other voter when id is empty. Applies in flight are drained first.
+func (l *DistributedLog) TransferLeadership(id string) error {
+	if l.raft.State() != raft.Leader {
+		return raft.ErrNotLeader
+	}
+
+	l.applies.Lock()
+	defer l.applies.Unlock()
+
+	configFuture := l.raft.GetConfiguration()
+	if err := configFuture.Error(); err != nil {
+		return err
+	}
+	// raft would also pick non-voters, which can't win the election
+	var target *raft.Server
+	for _, server := range configFuture.Configuration().Servers {
+		if server.ID == l.config.Raft.LocalID || server.Suffrage != raft.Voter {
+			continue
+		}
+		if id == "" || server.ID == raft.ServerID(id) {
+			target = &server
+			break
+		}
+	}
+	if target == nil {
+		if id == "" {
+			return fmt.Errorf("no voter to transfer leadership to")
+		}
+		return fmt.Errorf("server %s isn't a voter", id)
+	}
+	future := l.raft.LeadershipTransferToServer(target.ID, target.Address)
+	return future.Error()
+}
+
+func (l *DistributedLog) IsLeader() bool {
+	return l.raft.State() == raft.Leader
+}
+
+// LeaderCh gets true when this node becomes the leader and false when it
+// stops being the leader.
+func (l *DistributedLog) LeaderCh() <-chan bool {
+	return l.raft.LeaderCh()
+}
+
 func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
 	timeoutc := time.After(timeout)
 	ticker := time.NewTicker(time.Second)
@@ -240,11 +815,23 @@ func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
 }
 
 func (l *DistributedLog) Close() error {
+	if l.autopilot != nil {
+		l.autopilot.stop()
+	}
 	f := l.raft.Shutdown()
+	// the workers' applies fail once raft is shut down
+	l.shutdownOnce.Do(func() { close(l.shutdownCh) })
+	l.workers.Wait()
 	if err := f.Error(); err != nil {
 		return err
 	}
-	return l.log.Close()
+	if err := l.logStore.Close(); err != nil {
+		return err
+	}
+	if err := l.stableStore.Close(); err != nil {
+		return err
+	}
+	return l.fsm.close()
 }
 
 func (l *DistributedLog) GetServers() ([]*api.Server, error) {
@@ -258,93 +845,532 @@ func (l *DistributedLog) GetServers() ([]*api.Server, error) {
 			Id:       string(server.ID),
 			RpcAddr:  string(server.Address),
 			IsLeader: l.raft.Leader() == server.Address,
+			Role:     role(server.Suffrage),
 		})
 	}
 	return servers, nil
 }
 
-var _ raft.FSM = (*fsm)(nil)
+func role(suffrage raft.ServerSuffrage) string {
+	if suffrage == raft.Nonvoter {
+		return api.RoleNonvoter
+	}
+	return api.RoleVoter
+}
+
+var _ raft.BatchingFSM = (*fsm)(nil)
 
 func (l *fsm) Apply(record *raft.Log) interface{} {
+	res := l.apply(record)
+	if RequestType(record.Data[0]) == AppendRequestType {
+		l.notifier.notify()
+	}
+	return res
+}
+
+// ApplyBatch applies the committed entries raft has batched, they also
+// include raft's configuration changes which the FSM has nothing to do with.
+// Readers waiting for records are woken once for the whole batch.
+func (l *fsm) ApplyBatch(logs []*raft.Log) []interface{} {
+	res := make([]interface{}, len(logs))
+	appended := false
+	for i, log := range logs {
+		if log.Type != raft.LogCommand {
+			continue
+		}
+		res[i] = l.apply(log)
+		appended = appended || RequestType(log.Data[0]) == AppendRequestType
+	}
+	if appended {
+		l.notifier.notify()
+	}
+	return res
+}
+
+func (l *fsm) apply(record *raft.Log) interface{} {
 	buf := record.Data
 	reqType := RequestType(buf[0])
 	switch reqType {
 	case AppendRequestType:
-		return l.applyAppend(buf[1:])
+		return l.applyAppend(record.Index, buf[1:])
 	}
-	return nil
+	if cmd, ok := l.commands[reqType]; ok {
+		return cmd.Apply(buf[1:])
+	}
+	// every server must reject it the same way to stay consistent
+	return fmt.Errorf("%w: %d", ErrUnknownRequestType, reqType)
 }
 
-func (l *fsm) applyAppend(b []byte) interface{} {
-	var req api.ProduceRequest
-	err := proto.Unmarshal(b, &req)
+// applyAppend makes the entry's records readable, the records are only
+// counted since they're read from raft's log.
+func (l *fsm) applyAppend(index uint64, b []byte) interface{} {
+	size, err := countRecords(b)
 	if err != nil {
 		return err
 	}
-	offset, err := l.log.Append(req.Record)
-	if err != nil {
-		return err
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	offset := l.nextOffset
+	if size == 0 {
+		return &api.ProduceResponse{Offset: offset}
 	}
+	if n := len(l.runs); n == 0 || l.runs[n-1].size != size ||
+		l.runs[n-1].index+(offset-l.runs[n-1].offset)/size != index {
+		l.runs = append(l.runs, run{offset: offset, index: index, size: size})
+	}
+	l.nextOffset += size
 	return &api.ProduceResponse{Offset: offset}
 }
 
+// countRecords counts the records in an api.Records without decoding them.
+func countRecords(b []byte) (uint64, error) {
+	var n uint64
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return 0, protowire.ParseError(l)
+		}
+		b = b[l:]
+		l = protowire.ConsumeFieldValue(num, typ, b)
+		if l < 0 {
+			return 0, protowire.ParseError(l)
+		}
+		b = b[l:]
+		if num == 1 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// index returns the raft index holding the record at the offset and the
+// record's position within the entry.
+func (l *fsm) index(offset uint64) (uint64, uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if len(l.runs) == 0 || offset < l.runs[0].offset || offset >= l.nextOffset {
+		return 0, 0, api.ErrOffsetOutOfRange{Offset: offset}
+	}
+	i := sort.Search(len(l.runs), func(i int) bool {
+		return l.runs[i].offset > offset
+	}) - 1
+	r := l.runs[i]
+	return r.index + (offset-r.offset)/r.size, (offset - r.offset) % r.size, nil
+}
+
+// readLegacy reads the record at the offset from the legacy records, ok is
+// false when it's not one of them.
+func (l *fsm) readLegacy(offset uint64) (record *api.Record, ok bool, err error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.legacy == nil || offset < l.legacyLowest || offset >= l.legacyNext() {
+		return nil, false, nil
+	}
+	record, err = l.legacy.Read(offset)
+	return record, true, err
+}
+
+// legacyNext returns the offset past the legacy records, the runs' records
+// start there.
+func (l *fsm) legacyNext() uint64 {
+	if len(l.runs) > 0 {
+		return l.runs[0].offset
+	}
+	return l.nextOffset
+}
+
+// offsets returns the lowest record's offset and the offset the next record
+// gets.
+func (l *fsm) offsets() (uint64, uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.legacy != nil {
+		return l.legacyLowest, l.nextOffset
+	}
+	if len(l.runs) == 0 {
+		return l.nextOffset, l.nextOffset
+	}
+	return l.runs[0].offset, l.nextOffset
+}
+
+// Snapshots from before the records were kept in raft's log hold the
+// records themselves, each preceded by its length. Those with commands'
+// state start with snapshotMarker, which also ends their records. Later
+// snapshots start with runsMarker. Neither can be a record's length.
+const (
+	snapshotMarker = math.MaxUint64
+	runsMarker     = math.MaxUint64 - 1
+)
+
+// Snapshots hold where the records are in raft's log rather than the records
+// themselves, followed by the legacy records, which raft's log no longer
+// has, ended by snapshotMarker and each command's state.
+//
+// Raft sends the snapshot to the followers missing entries its log no longer
+// starts at, they'd miss the runs' entries too. Once raft's log doesn't
+// start at its first entry, the snapshots hold the runs' records as well,
+// after the legacy ones and like them.
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
-	r := f.log.Reader()
-	return &snapshot{reader: r}, nil
+	var entries *logStore
+	if f.entries != nil {
+		first, err := f.entries.FirstIndex()
+		if err != nil {
+			return nil, err
+		}
+		if first > 1 {
+			entries = f.entries
+		}
+	}
+	f.mu.RLock()
+	s := &snapshot{
+		runs:       append([]run(nil), f.runs...),
+		nextOffset: f.nextOffset,
+		entries:    entries,
+	}
+	if f.legacy != nil {
+		s.legacy = f.legacy.Reader()
+	}
+	f.mu.RUnlock()
+	for reqType, cmd := range f.commands {
+		snapshotter, ok := cmd.(Snapshotter)
+		if !ok {
+			continue
+		}
+		state, err := snapshotter.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		s.states = append(s.states, commandState{reqType: reqType, state: state})
+	}
+	sort.Slice(s.states, func(i, j int) bool {
+		return s.states[i].reqType < s.states[j].reqType
+	})
+	return s, nil
 }
 
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 type snapshot struct {
-	reader io.Reader
+	runs       []run
+	nextOffset uint64
+	legacy     io.Reader
+	// entries is set when the runs' records are persisted too.
+	entries *logStore
+	states  []commandState
+}
+
+type commandState struct {
+	reqType RequestType
+	state   []byte
 }
 
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	if _, err := io.Copy(sink, s.reader); err != nil {
+	if err := s.persist(sink); err != nil {
 		_ = sink.Cancel()
 		return err
 	}
 	return sink.Close()
 }
 
+func (s *snapshot) persist(w io.Writer) error {
+	header := []uint64{runsMarker, s.nextOffset, uint64(len(s.runs))}
+	for _, r := range s.runs {
+		header = append(header, r.offset, r.index, r.size)
+	}
+	if err := binary.Write(w, enc, header); err != nil {
+		return err
+	}
+	if s.legacy != nil {
+		if _, err := io.Copy(w, s.legacy); err != nil {
+			return err
+		}
+	}
+	if s.entries != nil {
+		if err := s.persistRuns(w); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(w, enc, uint64(snapshotMarker)); err != nil {
+		return err
+	}
+	for _, cs := range s.states {
+		if _, err := w.Write([]byte{byte(cs.reqType)}); err != nil {
+			return err
+		}
+		if err := binary.Write(w, enc, uint64(len(cs.state))); err != nil {
+			return err
+		}
+		if _, err := w.Write(cs.state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistRuns writes the runs' records the way the legacy ones are, each
+// preceded by its length. Raft doesn't compact the entries the snapshot
+// covers, they're committed.
+func (s *snapshot) persistRuns(w io.Writer) error {
+	lenBuf := make([]byte, lenWidth)
+	for i, r := range s.runs {
+		end := s.nextOffset
+		if i+1 < len(s.runs) {
+			end = s.runs[i+1].offset
+		}
+		for n := uint64(0); n < (end-r.offset)/r.size; n++ {
+			entry, err := s.entries.Read(r.index + n)
+			if err != nil {
+				return err
+			}
+			var records api.Records
+			if err := proto.Unmarshal(entry.Value[1:], &records); err != nil {
+				return err
+			}
+			if uint64(len(records.Records)) != r.size {
+				return fmt.Errorf(
+					"raft log entry %d has %d records, not %d",
+					r.index+n, len(records.Records), r.size,
+				)
+			}
+			for j, record := range records.Records {
+				record.Offset = r.offset + n*r.size + uint64(j)
+				b, err := proto.Marshal(record)
+				if err != nil {
+					return err
+				}
+				enc.PutUint64(lenBuf, uint64(len(b)))
+				if _, err := w.Write(lenBuf); err != nil {
+					return err
+				}
+				if _, err := w.Write(b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 func (s *snapshot) Release() {}
 
-func (f *fsm) Restore(r io.ReadCloser) error {
+// Restore restores the snapshots from before the records were kept in
+// raft's log too: their records become the legacy records, and the records
+// raft applies next follow them. So do the runs' records of the snapshots
+// holding them, raft's log may not have their entries.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	r := bufio.NewReader(rc)
+	var runs []run
+	var nextOffset uint64
+	withStates := true
+	peek, err := r.Peek(lenWidth)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case len(peek) == lenWidth && enc.Uint64(peek) == runsMarker:
+		var header [3]uint64
+		if err := binary.Read(r, enc, &header); err != nil {
+			return err
+		}
+		nextOffset = header[1]
+		runs = make([]run, header[2])
+		for i := range runs {
+			var fields [3]uint64
+			if err := binary.Read(r, enc, &fields); err != nil {
+				return err
+			}
+			runs[i] = run{offset: fields[0], index: fields[1], size: fields[2]}
+		}
+	case len(peek) == lenWidth && enc.Uint64(peek) == snapshotMarker:
+		if _, err := r.Discard(lenWidth); err != nil {
+			return err
+		}
+	default:
+		withStates = false
+	}
+
+	legacy, lowest, next, err := f.restoreLegacy(r, withStates)
+	if err != nil {
+		return err
+	}
+	if legacy, err = f.moveLegacy(legacy); err != nil {
+		return err
+	}
+	if legacy != nil && next == nextOffset {
+		runs = nil
+	}
+	if runs == nil && legacy != nil {
+		nextOffset = next
+	}
+	f.mu.Lock()
+	old := f.legacy
+	f.runs = runs
+	f.nextOffset = nextOffset
+	f.legacy = legacy
+	f.legacyLowest = lowest
+	f.mu.Unlock()
+	if old != nil {
+		if err := old.Close(); err != nil {
+			return err
+		}
+	}
+	f.notifier.notify()
+	if !withStates {
+		return nil
+	}
+	return f.restoreStates(r)
+}
+
+// restoreLegacy writes the snapshot's legacy records to a new log next to
+// legacyDir, and returns it with the offsets of its records. The log is nil
+// when the snapshot has none.
+func (f *fsm) restoreLegacy(r *bufio.Reader, withStates bool) (
+	legacy *Log,
+	lowest, next uint64,
+	err error,
+) {
+	defer func() {
+		if err != nil && legacy != nil {
+			_ = legacy.Remove()
+			legacy = nil
+		}
+	}()
 	b := make([]byte, lenWidth)
 	var buf bytes.Buffer
-	for i := 0; ; i++ {
+	for {
 		_, err := io.ReadFull(r, b)
-		if err == io.EOF {
+		if err == io.EOF && !withStates {
 			break
+		} else if err != nil {
+			return legacy, 0, 0, err
+		}
+		size := enc.Uint64(b)
+		if withStates && size == snapshotMarker {
+			break
+		}
+		buf.Reset()
+		if _, err := io.CopyN(&buf, r, int64(size)); err != nil {
+			return legacy, 0, 0, err
+		}
+		record := &api.Record{}
+		if err := proto.Unmarshal(buf.Bytes(), record); err != nil {
+			return legacy, 0, 0, err
+		}
+		if legacy == nil {
+			if legacy, err = f.newLegacy(record.Offset); err != nil {
+				return nil, 0, 0, err
+			}
+			lowest = record.Offset
+		}
+		if _, err := legacy.Append(record); err != nil {
+			return legacy, 0, 0, err
+		}
+		next = record.Offset + 1
+	}
+	return legacy, lowest, next, nil
+}
+
+// newLegacy creates the log the legacy records are restored to, it replaces
+// the one in legacyDir once they all are.
+func (f *fsm) newLegacy(initialOffset uint64) (*Log, error) {
+	if f.legacyDir == "" {
+		return nil, fmt.Errorf("snapshot holds records but there's no directory to restore them to")
+	}
+	dir := f.legacyDir + ".restore"
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var c Config
+	c.Segment.InitialOffset = initialOffset
+	return NewLog(dir, c)
+}
+
+// moveLegacy moves the restored legacy records to legacyDir, in place of
+// the previous ones. Those are only removed once the restored ones are
+// closed, and so synced, and in their place: until then, a node restarting
+// midway restores them from the snapshot again.
+func (f *fsm) moveLegacy(legacy *Log) (*Log, error) {
+	if f.legacyDir == "" {
+		return legacy, nil
+	}
+	previous := f.legacyDir + ".previous"
+	if err := os.RemoveAll(previous); err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		if err := legacy.Close(); err != nil {
+			return nil, err
+		}
+	}
+	err := os.Rename(f.legacyDir, previous)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if legacy != nil {
+		if err := os.Rename(legacy.Dir, f.legacyDir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.RemoveAll(previous); err != nil {
+		return nil, err
+	}
+	if legacy == nil {
+		return nil, nil
+	}
+	return NewLog(f.legacyDir, legacy.Config)
+}
+
+func (f *fsm) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.legacy == nil {
+		return nil
+	}
+	return f.legacy.Close()
+}
+
+func (f *fsm) restoreStates(r io.Reader) error {
+	reqType := make([]byte, 1)
+	for {
+		_, err := io.ReadFull(r, reqType)
+		if err == io.EOF {
+			return nil
 		} else if err != nil {
 			return err
 		}
-		size := int64(enc.Uint64(b))
-		if _, err := io.CopyN(&buf, r, size); err != nil {
+		var size uint64
+		if err := binary.Read(r, enc, &size); err != nil {
 			return err
 		}
-		record := &api.Record{}
-		if err = proto.Unmarshal(buf.Bytes(), record); err != nil {
+		state := make([]byte, size)
+		if _, err := io.ReadFull(r, state); err != nil {
 			return err
 		}
-		if i == 0 {
-			f.log.Config.Segment.InitialOffset = record.Offset
-			if err := f.log.Reset(); err != nil {
-				return err
-			}
+		snapshotter, ok := f.commands[RequestType(reqType[0])].(Snapshotter)
+		if !ok {
+			return fmt.Errorf(
+				"snapshot has state for request type %d without a command to restore it",
+				reqType[0],
+			)
 		}
-		if _, err := f.log.Append(record); err != nil {
+		if err := snapshotter.Restore(state); err != nil {
 			return err
 		}
-		buf.Reset()
 	}
-	return nil
 }
 
 var _ raft.LogStore = (*logStore)(nil)
 
 type logStore struct {
 	*Log
+	// applied returns raft's applied index, the entries up to it are
+	// committed.
+	applied func() uint64
+	// onStore is given the extensions of the entries once they're stored.
+	onStore func(ext []byte)
 }
 
 func newLogStore(dir string, c Config) (*logStore, error) {
@@ -352,19 +1378,44 @@ func newLogStore(dir string, c Config) (*logStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &logStore{log}, nil
+	return &logStore{Log: log, applied: func() uint64 { return 0 }}, nil
 }
 
 func (l *logStore) FirstIndex() (uint64, error) {
+	if empty, err := l.empty(); empty || err != nil {
+		return 0, err
+	}
 	return l.LowestOffset()
 }
 
 func (l *logStore) LastIndex() (uint64, error) {
+	if empty, err := l.empty(); empty || err != nil {
+		return 0, err
+	}
 	return l.HighestOffset()
 }
 
+// empty reports whether every entry was truncated, raft expects the first
+// and last indexes of an empty store to be 0.
+func (l *logStore) empty() (bool, error) {
+	lowest, err := l.LowestOffset()
+	if err != nil {
+		return false, err
+	}
+	highest, err := l.HighestOffset()
+	if err != nil {
+		return false, err
+	}
+	return lowest > highest, nil
+}
+
+// GetLog returns raft.ErrLogNotFound for the entries the store doesn't have,
+// raft sends followers needing those a snapshot instead.
 func (l *logStore) GetLog(index uint64, out *raft.Log) error {
 	in, err := l.Read(index)
+	if _, ok := err.(api.ErrOffsetOutOfRange); ok {
+		return raft.ErrLogNotFound
+	}
 	if err != nil {
 		return err
 	}
@@ -381,21 +1432,63 @@ func (l *logStore) StoreLog(record *raft.Log) error {
 }
 
 func (l *logStore) StoreLogs(records []*raft.Log) error {
-	for _, record := range records {
-
-		if _, err := l.Append(&api.Record{
+	if len(records) == 0 {
+		return nil
+	}
+	// the entries are stored at their index, raft's indexes start at 1
+	highest, err := l.HighestOffset()
+	if err != nil {
+		return err
+	}
+	if first := records[0].Index; first > highest+1 {
+		// raft leaves a gap after installing a snapshot, the entries before
+		// the gap are the snapshot's and its records were restored from it
+		if err := l.Truncate(first - 1); err != nil {
+			return err
+		}
+		highest = first - 1
+	}
+	entries := make([]*api.Record, len(records))
+	for i, record := range records {
+		if record.Index != highest+1+uint64(i) {
+			return fmt.Errorf(
+				"raft log entry %d doesn't follow entry %d",
+				record.Index, highest+uint64(i),
+			)
+		}
+		entries[i] = &api.Record{
 			Value: record.Data,
 			Term:  record.Term,
 			Type:  uint32(record.Type),
-		}); err != nil {
-			return err
+		}
+	}
+	if _, err = l.AppendBatch(entries); err != nil {
+		return err
+	}
+	// raft counts the entries as stored once this returns, and so do the
+	// leader acks
+	if err := l.Sync(); err != nil {
+		return err
+	}
+	if l.onStore != nil {
+		for _, record := range records {
+			if len(record.Extensions) > 0 {
+				l.onStore(record.Extensions)
+			}
 		}
 	}
 	return nil
 }
 
+// DeleteRange removes the entries that conflict with the leader's, they're
+// uncommitted so they're always the end of the log. Raft also compacts the
+// committed entries after snapshots, but those are the log's records, so
+// they're kept.
 func (l *logStore) DeleteRange(min, max uint64) error {
-	return l.Truncate(max)
+	if min <= l.applied() {
+		return nil
+	}
+	return l.TruncateFrom(min)
 }
 
 // compile-time check. assert that StreamLayer implements raft.StreamLayer
@@ -1,18 +1,24 @@
 package log_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/raft"
 	api "github.com/madalosso/proglog/api/v1"
 	"github.com/madalosso/proglog/internal/log"
+	"github.com/madalosso/proglog/internal/log/logtest"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestMultipleNodes(t *testing.T) {
@@ -48,7 +54,7 @@ func TestMultipleNodes(t *testing.T) {
 		require.NoError(t, err)
 		if i != 0 {
 			err = logs[0].Join(
-				fmt.Sprintf("%d", i), ln.Addr().String(),
+				fmt.Sprintf("%d", i), ln.Addr().String(), api.RoleVoter,
 			)
 			require.NoError(t, err)
 		} else {
@@ -122,3 +128,714 @@ func TestMultipleNodes(t *testing.T) {
 	require.Equal(t, off, record.Offset)
 
 }
+
+func TestNonvoter(t *testing.T) {
+	ports := dynaport.Get(2)
+	leader := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, leader.WaitForLeader(3*time.Second))
+
+	replica := logtest.NewDistributedLog(t, "1", ports[1], false)
+	replicaAddr := fmt.Sprintf("127.0.0.1:%d", ports[1])
+	require.NoError(t, leader.Join("1", replicaAddr, api.RoleNonvoter))
+
+	servers, err := leader.GetServers()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(servers))
+	require.Equal(t, api.RoleVoter, servers[0].Role)
+	require.Equal(t, api.RoleNonvoter, servers[1].Role)
+
+	off, err := leader.Append(&api.Record{Value: []byte("replicated")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, err := replica.Read(off)
+		return err == nil && string(got.Value) == "replicated"
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	// joining again with the voter role promotes the replica, after which
+	// either voter can lead
+	require.NoError(t, leader.Join("1", replicaAddr, api.RoleVoter))
+	servers, err = leaderOf(t, leader, replica).GetServers()
+	require.NoError(t, err)
+	require.Equal(t, api.RoleVoter, servers[1].Role)
+}
+
+func TestJoinCompactedLeader(t *testing.T) {
+	ports := dynaport.Get(2)
+	smallSegments := func(c *log.Config) {
+		c.Segment.MaxStoreBytes = 256
+	}
+	leader := logtest.NewDistributedLog(t, "0", ports[0], true, smallSegments)
+	require.NoError(t, leader.WaitForLeader(3*time.Second))
+
+	var values []string
+	for i := 0; i < 20; i++ {
+		values = append(values, fmt.Sprintf("record %d", i))
+		_, err := leader.Append(&api.Record{Value: []byte(values[i])})
+		require.NoError(t, err)
+	}
+	require.NoError(t, leader.CompactRaftLog())
+
+	// the follower gets the records from the leader's snapshot, then the
+	// entries past it
+	follower := logtest.NewDistributedLog(t, "1", ports[1], false, smallSegments)
+	require.NoError(t, leader.Join("1", fmt.Sprintf("127.0.0.1:%d", ports[1]), api.RoleNonvoter))
+	values = append(values, "after the snapshot")
+	off, err := leader.Append(&api.Record{Value: []byte(values[20])})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err := follower.Read(off)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+
+	for off, want := range values {
+		got, err := follower.Read(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want, string(got.Value))
+	}
+}
+
+// leaderOf waits for one of the logs to lead and returns it. With the tests'
+// short timeouts any voter that joined can win an election, so tests can't
+// count on the bootstrapped log staying leader.
+func leaderOf(t *testing.T, logs ...*log.DistributedLog) *log.DistributedLog {
+	t.Helper()
+	var leader *log.DistributedLog
+	require.Eventually(t, func() bool {
+		for _, l := range logs {
+			if l.IsLeader() {
+				leader = l
+				return true
+			}
+		}
+		return false
+	}, 3*time.Second, 20*time.Millisecond)
+	return leader
+}
+
+func TestTransferLeadership(t *testing.T) {
+	ports := dynaport.Get(3)
+	var logs []*log.DistributedLog
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("%d", i)
+		l := logtest.NewDistributedLog(t, id, ports[i], i == 0)
+		if i == 0 {
+			require.NoError(t, l.WaitForLeader(3*time.Second))
+		} else {
+			addr := fmt.Sprintf("127.0.0.1:%d", ports[i])
+			require.NoError(t, logs[0].Join(id, addr, api.RoleVoter))
+		}
+		logs = append(logs, l)
+	}
+
+	isLeader := func(l *log.DistributedLog, id string) bool {
+		servers, err := l.GetServers()
+		if err != nil {
+			return false
+		}
+		for _, server := range servers {
+			if server.IsLeader {
+				return server.Id == id
+			}
+		}
+		return false
+	}
+	// with these short timeouts any server can win an election, so find
+	// the leader rather than assume it's 0
+	leader := func() int {
+		found := -1
+		require.Eventually(t, func() bool {
+			for i := range logs {
+				id := fmt.Sprintf("%d", i)
+				if isLeader(logs[0], id) && isLeader(logs[1], id) && isLeader(logs[2], id) {
+					found = i
+					return true
+				}
+			}
+			return false
+		}, 3*time.Second, 50*time.Millisecond)
+		return found
+	}
+	first := leader()
+	target, follower := (first+1)%3, (first+2)%3
+
+	// only the leader can hand leadership over
+	require.Equal(t, raft.ErrNotLeader, logs[follower].TransferLeadership(fmt.Sprintf("%d", target)))
+
+	require.NoError(t, logs[first].TransferLeadership(fmt.Sprintf("%d", target)))
+	require.Eventually(t, func() bool {
+		return isLeader(logs[target], fmt.Sprintf("%d", target))
+	}, time.Second, 50*time.Millisecond)
+
+	off, err := logs[target].Append(&api.Record{Value: []byte("after transfer")})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		got, err := logs[first].Read(off)
+		return err == nil && string(got.Value) == "after transfer"
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	// without a target any other voter takes over
+	require.NoError(t, logs[target].TransferLeadership(""))
+	require.Eventually(t, func() bool {
+		for i, l := range logs {
+			if i != target && isLeader(l, fmt.Sprintf("%d", i)) {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestListPeers(t *testing.T) {
+	ports := dynaport.Get(2)
+	first := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, first.WaitForLeader(3*time.Second))
+	second := logtest.NewDistributedLog(t, "1", ports[1], false)
+	require.NoError(t, first.AddVoter("1", fmt.Sprintf("127.0.0.1:%d", ports[1])))
+
+	leader := leaderOf(t, first, second)
+	follower, leaderID, followerID := second, "0", "1"
+	if leader == second {
+		follower, leaderID, followerID = first, "1", "0"
+	}
+	peer := func(res *api.ListPeersResponse, id string) *api.Peer {
+		for _, p := range res.Peers {
+			if p.Id == id {
+				return p
+			}
+		}
+		return &api.Peer{}
+	}
+
+	_, err := leader.Append(&api.Record{Value: []byte("peers")})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		res, err := leader.ListPeers()
+		if err != nil || len(res.Peers) != 2 {
+			return false
+		}
+		return res.State == raft.Leader.String() &&
+			peer(res, leaderID).IsLeader &&
+			peer(res, followerID).MatchIndex == res.LastIndex &&
+			peer(res, followerID).Lag == 0
+	}, time.Second, 50*time.Millisecond)
+
+	res, err := follower.ListPeers()
+	require.NoError(t, err)
+	require.Equal(t, raft.Follower.String(), res.State)
+
+	require.NoError(t, leader.DemoteVoter(followerID))
+	res, err = leader.ListPeers()
+	require.NoError(t, err)
+	require.Equal(t, api.RoleNonvoter, peer(res, followerID).Role)
+
+	require.Equal(t, raft.Leader.String(), leader.Stats()["state"])
+}
+
+func TestInvalidRaftConfig(t *testing.T) {
+	config := log.Config{}
+	config.Raft.LocalID = "0"
+	// raft's default heartbeat timeout is longer than this election timeout
+	config.Raft.ElectionTimeout = 100 * time.Millisecond
+	_, err := log.NewDistributedLog(t.TempDir(), config)
+	require.Error(t, err)
+
+	config.Raft.ElectionTimeout = 0
+	config.Raft.ApplyTimeout = -time.Second
+	_, err = log.NewDistributedLog(t.TempDir(), config)
+	require.Error(t, err)
+}
+
+func TestRecoverCluster(t *testing.T) {
+	ports := dynaport.Get(3)
+	dataDir := t.TempDir()
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[0])
+	newLeader := func() *log.DistributedLog {
+		ln, err := net.Listen("tcp", addr)
+		require.NoError(t, err)
+		config := log.Config{}
+		config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = "0"
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+		config.Raft.BindAddr = addr
+		config.Raft.Bootstrap = true
+		l, err := log.NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+		require.NoError(t, l.WaitForLeader(3*time.Second))
+		return l
+	}
+
+	logs := []*log.DistributedLog{newLeader()}
+	// a joining server can win an election with these short timeouts, so
+	// send the requests to whichever server leads
+	onLeader := func(fn func(l *log.DistributedLog) error) {
+		require.Eventually(t, func() bool {
+			for _, l := range logs {
+				if l.IsLeader() {
+					return fn(l) == nil
+				}
+			}
+			return false
+		}, 3*time.Second, 50*time.Millisecond)
+	}
+	for i := 1; i < 3; i++ {
+		id := fmt.Sprintf("%d", i)
+		l := logtest.NewDistributedLog(t, id, ports[i], false)
+		onLeader(func(leader *log.DistributedLog) error {
+			return leader.Join(id, fmt.Sprintf("127.0.0.1:%d", ports[i]), api.RoleVoter)
+		})
+		logs = append(logs, l)
+	}
+	var off uint64
+	onLeader(func(leader *log.DistributedLog) (err error) {
+		off, err = leader.Append(&api.Record{Value: []byte("before")})
+		return err
+	})
+	require.Eventually(t, func() bool {
+		_, err := logs[0].Read(off)
+		return err == nil
+	}, time.Second, 50*time.Millisecond)
+
+	// lose the quorum, only node 0 survives
+	for _, l := range logs {
+		require.NoError(t, l.Close())
+	}
+
+	config := log.Config{}
+	config.Raft.LocalID = "0"
+	err := log.RecoverCluster(dataDir, config, []raft.Server{
+		{Suffrage: raft.Voter, ID: "0", Address: raft.ServerAddress(addr)},
+	})
+	require.NoError(t, err)
+
+	l := newLeader()
+	defer l.Close()
+
+	got, err := l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("before"), got.Value)
+
+	off, err = l.Append(&api.Record{Value: []byte("after")})
+	require.NoError(t, err)
+	got, err = l.Read(off)
+	require.NoError(t, err)
+	require.Equal(t, []byte("after"), got.Value)
+}
+
+func TestAutopilot(t *testing.T) {
+	ports := dynaport.Get(4)
+	autopilot := func(c *log.Config) {
+		c.Autopilot.Enabled = true
+		c.Autopilot.Interval = 20 * time.Millisecond
+		c.Autopilot.StabilizationTime = 200 * time.Millisecond
+		c.Autopilot.DeadServerThreshold = 500 * time.Millisecond
+		c.Autopilot.MaxVoters = 3
+	}
+	first := logtest.NewDistributedLog(t, "0", ports[0], true, autopilot)
+	require.NoError(t, first.WaitForLeader(3*time.Second))
+
+	logs := []*log.DistributedLog{first}
+	for i := 1; i < 4; i++ {
+		id := fmt.Sprintf("%d", i)
+		logs = append(logs, logtest.NewDistributedLog(t, id, ports[i], false, autopilot))
+		addr := fmt.Sprintf("127.0.0.1:%d", ports[i])
+		require.NoError(t, first.Join(id, addr, api.RoleVoter))
+	}
+
+	// once there are other voters any of them can win an election, so roles
+	// are read from whichever leads
+	roles := func() map[string]string {
+		servers, err := leaderOf(t, logs...).GetServers()
+		require.NoError(t, err)
+		roles := make(map[string]string)
+		for _, server := range servers {
+			roles[server.Id] = server.Role
+		}
+		return roles
+	}
+
+	// servers join as non-voters until they're stable
+	require.Equal(t, api.RoleNonvoter, roles()["1"])
+
+	// promoted up to the voter limit
+	require.Eventually(t, func() bool {
+		r := roles()
+		return r["1"] == api.RoleVoter &&
+			r["2"] == api.RoleVoter &&
+			r["3"] == api.RoleNonvoter
+	}, 3*time.Second, 50*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, api.RoleNonvoter, roles()["3"])
+
+	// kill a voter that isn't leading
+	leader, victim := leaderOf(t, logs...), 1
+	if leader == logs[victim] {
+		victim = 2
+	}
+	dead := fmt.Sprintf("%d", victim)
+
+	// failures are left for autopilot to clean up
+	require.NoError(t, leader.Fail(dead))
+	require.Contains(t, roles(), dead)
+
+	// the dead server is removed, which makes room to promote the last one
+	require.NoError(t, logs[victim].Close())
+	require.Eventually(t, func() bool {
+		r := roles()
+		_, ok := r[dead]
+		return !ok && r["3"] == api.RoleVoter
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func TestAutopilotAfterLeaderChange(t *testing.T) {
+	ports := dynaport.Get(3)
+	autopilot := func(c *log.Config) {
+		c.Autopilot.Enabled = true
+		c.Autopilot.Interval = 20 * time.Millisecond
+		c.Autopilot.StabilizationTime = 200 * time.Millisecond
+		// "2" wants to vote, whichever leader it joined through
+		c.Autopilot.Candidate = func(id raft.ServerID) bool {
+			return id == "2"
+		}
+	}
+	var logs []*log.DistributedLog
+	for i := 0; i < 3; i++ {
+		logs = append(logs, logtest.NewDistributedLog(t, fmt.Sprintf("%d", i), ports[i], i == 0, autopilot))
+	}
+	require.NoError(t, logs[0].WaitForLeader(3*time.Second))
+	roles := func() map[string]string {
+		servers, err := leaderOf(t, logs...).GetServers()
+		require.NoError(t, err)
+		roles := make(map[string]string)
+		for _, server := range servers {
+			roles[server.Id] = server.Role
+		}
+		return roles
+	}
+
+	require.NoError(t, logs[0].Join("1", fmt.Sprintf("127.0.0.1:%d", ports[1]), api.RoleVoter))
+	require.Eventually(t, func() bool {
+		return roles()["1"] == api.RoleVoter
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// joined as a non-voter, the current leader never takes it for a
+	// candidate
+	leader := leaderOf(t, logs...)
+	require.NoError(t, leader.Join("2", fmt.Sprintf("127.0.0.1:%d", ports[2]), api.RoleNonvoter))
+	time.Sleep(300 * time.Millisecond)
+	require.Equal(t, api.RoleNonvoter, roles()["2"])
+
+	// the next leader finds it among the configuration's non-voters
+	require.NoError(t, leader.TransferLeadership(""))
+	require.Eventually(t, func() bool {
+		return !leader.IsLeader() && roles()["2"] == api.RoleVoter
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+// registers keeps the last value registered under each record's value,
+// replicated through raft alongside the log.
+type registers struct {
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+func (r *registers) Apply(req []byte) interface{} {
+	var record api.Record
+	if err := proto.Unmarshal(req, &record); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.values[string(record.Value)] = record.Offset
+	return record.Offset
+}
+
+func (r *registers) get(key string) (uint64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.values[key]
+	return v, ok
+}
+
+func TestCommands(t *testing.T) {
+	const setRequestType log.RequestType = 1
+
+	ports := dynaport.Get(2)
+	var regs []*registers
+	var logs []*log.DistributedLog
+	for i := 0; i < 2; i++ {
+		r := &registers{values: make(map[string]uint64)}
+		l := logtest.NewDistributedLog(
+			t, fmt.Sprintf("%d", i), ports[i], i == 0,
+			func(c *log.Config) {
+				c.Commands = map[log.RequestType]log.Command{
+					setRequestType: r,
+				}
+			},
+		)
+		if i == 0 {
+			require.NoError(t, l.WaitForLeader(3*time.Second))
+		} else {
+			addr := fmt.Sprintf("127.0.0.1:%d", ports[i])
+			require.NoError(t, logs[0].Join("1", addr, api.RoleVoter))
+		}
+		regs = append(regs, r)
+		logs = append(logs, l)
+	}
+
+	res, err := logs[0].Apply(
+		setRequestType, &api.Record{Value: []byte("a"), Offset: 7},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), res)
+
+	require.Eventually(t, func() bool {
+		v, ok := regs[1].get("a")
+		return ok && v == 7
+	}, 500*time.Millisecond, 50*time.Millisecond)
+
+	// commands share the raft log with appends without disturbing them
+	off, err := logs[0].Append(&api.Record{Value: []byte("record")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	_, err = logs[0].Apply(2, &api.Record{})
+	require.True(t, errors.Is(err, log.ErrUnknownRequestType))
+}
+
+func TestReservedRequestType(t *testing.T) {
+	config := log.Config{}
+	config.Commands = map[log.RequestType]log.Command{
+		log.AppendRequestType: &registers{},
+	}
+	_, err := log.NewDistributedLog(t.TempDir(), config)
+	require.Error(t, err)
+}
+
+func TestConcurrentAppends(t *testing.T) {
+	ports := dynaport.Get(2)
+	batching := func(c *log.Config) {
+		c.Raft.BatchApplyCh = true
+		c.Raft.MaxAppendEntries = 128
+	}
+	leader := logtest.NewDistributedLog(t, "0", ports[0], true, batching)
+	require.NoError(t, leader.WaitForLeader(3*time.Second))
+	follower := logtest.NewDistributedLog(t, "1", ports[1], false, batching)
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[1])
+	require.NoError(t, leader.Join("1", addr, api.RoleVoter))
+	// either voter can lead once the follower joined
+	if leaderOf(t, leader, follower) == follower {
+		leader, follower = follower, leader
+	}
+
+	const count = 200
+	values := make([]string, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := fmt.Sprintf("record %d", i)
+			off, err := leader.Append(&api.Record{Value: []byte(value)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			values[off] = value
+		}(i)
+	}
+	wg.Wait()
+
+	// the appends shared raft entries
+	peers, err := leader.ListPeers()
+	require.NoError(t, err)
+	require.Less(t, peers.LastIndex, uint64(count))
+
+	require.Eventually(t, func() bool {
+		_, err := follower.Read(count - 1)
+		return err == nil
+	}, 3*time.Second, 50*time.Millisecond)
+	for off, value := range values {
+		for _, l := range []*log.DistributedLog{leader, follower} {
+			record, err := l.Read(uint64(off))
+			require.NoError(t, err)
+			require.Equal(t, value, string(record.Value))
+			require.Equal(t, uint64(off), record.Offset)
+		}
+	}
+}
+
+func TestAppendAcks(t *testing.T) {
+	ports := dynaport.Get(2)
+	leader := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, leader.WaitForLeader(3*time.Second))
+	follower := logtest.NewDistributedLog(t, "1", ports[1], false)
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[1])
+	require.NoError(t, leader.Join("1", addr, api.RoleVoter))
+	// either voter can lead once the follower joined
+	if leaderOf(t, leader, follower) == follower {
+		leader, follower = follower, leader
+	}
+
+	var want []string
+	for _, acks := range []api.Acks{
+		api.Acks_ACKS_NONE,
+		api.Acks_ACKS_LEADER,
+		api.Acks_ACKS_QUORUM,
+	} {
+		off, achieved, err := leader.AppendAcks(
+			&api.Record{Value: []byte(acks.String())}, acks,
+		)
+		require.NoError(t, err)
+		require.Equal(t, acks, achieved)
+		if acks == api.Acks_ACKS_QUORUM {
+			record, err := leader.Read(off)
+			require.NoError(t, err)
+			require.Equal(t, acks.String(), string(record.Value))
+		}
+		want = append(want, acks.String())
+	}
+	for i := 0; i < 50; i++ {
+		value := fmt.Sprintf("record %d", i)
+		_, _, err := leader.AppendAcks(&api.Record{Value: []byte(value)}, api.Acks_ACKS_NONE)
+		require.NoError(t, err)
+		want = append(want, value)
+	}
+
+	// the appends keep the order they were made in, even without acks
+	require.Eventually(t, func() bool {
+		var got []string
+		for off := uint64(0); off < uint64(len(want)); off++ {
+			record, err := follower.Read(off)
+			if err != nil {
+				return false
+			}
+			got = append(got, string(record.Value))
+		}
+		return reflect.DeepEqual(want, got)
+	}, time.Second, 50*time.Millisecond)
+
+	_, _, err := follower.AppendAcks(&api.Record{}, api.Acks_ACKS_NONE)
+	require.Equal(t, raft.ErrNotLeader, err)
+}
+
+func TestWait(t *testing.T) {
+	ports := dynaport.Get(2)
+	leader := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, leader.WaitForLeader(3*time.Second))
+	follower := logtest.NewDistributedLog(t, "1", ports[1], false)
+	addr := fmt.Sprintf("127.0.0.1:%d", ports[1])
+	require.NoError(t, leader.Join("1", addr, api.RoleVoter))
+	// either voter can lead once the follower joined
+	if leaderOf(t, leader, follower) == follower {
+		leader, follower = follower, leader
+	}
+
+	waited := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		waited <- follower.Wait(ctx, 0)
+	}()
+	_, err := leader.Append(&api.Record{Value: []byte("first")})
+	require.NoError(t, err)
+	require.NoError(t, <-waited)
+	record, err := follower.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), record.Value)
+}
+
+func TestStartOffset(t *testing.T) {
+	ports := dynaport.Get(1)
+	l := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+
+	off, err := l.StartOffset(api.Start_START_LATEST, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	lowest, err := l.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+	highest, err := l.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), highest)
+
+	off, err = l.StartOffset(api.Start_START_EARLIEST, 7)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+	off, err = l.StartOffset(api.Start_START_LATEST, 7)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+}
+
+func TestGetLogInfo(t *testing.T) {
+	ports := dynaport.Get(1)
+	l := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+
+	for i := 0; i < 3; i++ {
+		_, err := l.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	info, err := l.GetLogInfo()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), info.LowestOffset)
+	require.Equal(t, uint64(2), info.HighestOffset)
+	// the records sit behind the configuration entry raft appended
+	require.Less(t, uint64(3), info.CommitIndex)
+	require.Equal(t, int(info.SegmentCount), len(info.Segments))
+	require.NotZero(t, info.TotalBytes)
+}
+
+func TestAppendRecords(t *testing.T) {
+	ports := dynaport.Get(1)
+	l := logtest.NewDistributedLog(t, "0", ports[0], true)
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+
+	const count = 10
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func() {
+			errs <- appendRecords(l)
+		}()
+	}
+	for i := 0; i < count; i++ {
+		require.NoError(t, <-errs)
+	}
+}
+
+// appendRecords appends three records and checks they're read back at
+// consecutive offsets.
+func appendRecords(l *log.DistributedLog) error {
+	records := []*api.Record{
+		{Value: []byte("first")},
+		{Value: []byte("second")},
+		{Value: []byte("third")},
+	}
+	off, acks, err := l.AppendRecords(records, api.Acks_ACKS_QUORUM)
+	if err != nil {
+		return err
+	}
+	if acks != api.Acks_ACKS_QUORUM {
+		return fmt.Errorf("got %s acks", acks)
+	}
+	// concurrent appends don't come between the records
+	for i, record := range records {
+		read, err := l.Read(off + uint64(i))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(record.Value, read.Value) {
+			return fmt.Errorf("read %q at offset %d, want %q", read.Value, off+uint64(i), record.Value)
+		}
+	}
+	return nil
+}
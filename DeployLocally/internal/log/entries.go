@@ -0,0 +1,66 @@
+package log
+
+import (
+	"container/list"
+	"sync"
+
+	api "github.com/madalosso/proglog/api/v1"
+)
+
+// entryCacheSize is how many raft entries' records the log keeps decoded.
+// Consumers read a batch's records one after the other, so a few entries
+// per reader are enough.
+const entryCacheSize = 64
+
+// entryCache keeps the records of the raft entries read last, so reading an
+// entry's records one by one decodes the entry once. The entries it holds
+// are committed, which raft never changes.
+type entryCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+type cachedEntry struct {
+	index   uint64
+	records []*api.Record
+}
+
+func newEntryCache(size int) *entryCache {
+	return &entryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[uint64]*list.Element),
+	}
+}
+
+// get returns the records of the entry at the raft index, ok is false when
+// they're not cached. The records are shared, callers must not change them.
+func (c *entryCache) get(index uint64) (records []*api.Record, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[index]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedEntry).records, true
+}
+
+// add caches the records of the entry at the raft index, evicting the entry
+// read least recently when the cache is full.
+func (c *entryCache) add(index uint64, records []*api.Record) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[index]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[index] = c.order.PushFront(&cachedEntry{index: index, records: records})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedEntry).index)
+	}
+}
@@ -0,0 +1,27 @@
+package log
+
+import (
+	"testing"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryCache(t *testing.T) {
+	c := newEntryCache(2)
+	c.add(1, []*api.Record{{Value: []byte("first")}})
+	c.add(2, []*api.Record{{Value: []byte("second")}})
+
+	// reading the first entry keeps it over the second
+	records, ok := c.get(1)
+	require.True(t, ok)
+	require.Equal(t, []byte("first"), records[0].Value)
+	c.add(3, []*api.Record{{Value: []byte("third")}})
+
+	_, ok = c.get(2)
+	require.False(t, ok)
+	for _, index := range []uint64{1, 3} {
+		_, ok = c.get(index)
+		require.True(t, ok)
+	}
+}
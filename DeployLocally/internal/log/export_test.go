@@ -0,0 +1,28 @@
+package log
+
+import (
+	"bytes"
+	"io"
+)
+
+// CompactRaftLog leaves the log the way nodes that ran before the records
+// were kept in raft's log left it: the records are legacy ones, restored
+// from their snapshots, and raft's log was compacted up to the snapshot.
+func (l *DistributedLog) CompactRaftLog() error {
+	snap, err := l.fsm.Snapshot()
+	if err != nil {
+		return err
+	}
+	snap.(*snapshot).entries = l.logStore
+	var buf bytes.Buffer
+	if err := snap.(*snapshot).persist(&buf); err != nil {
+		return err
+	}
+	if err := l.fsm.Restore(io.NopCloser(&buf)); err != nil {
+		return err
+	}
+	if err := l.raft.Snapshot().Error(); err != nil {
+		return err
+	}
+	return l.logStore.Truncate(l.raft.AppliedIndex())
+}
@@ -0,0 +1,329 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFSMSnapshotRestore(t *testing.T) {
+	for scenario, commands := range map[string]func() map[RequestType]Command{
+		"records only": func() map[RequestType]Command {
+			return nil
+		},
+		"records and command state": func() map[RequestType]Command {
+			return map[RequestType]Command{1: &counter{}, 2: &noState{}}
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			f := newFSM(commands())
+
+			// raft's own entries and the commands' sit between records
+			var index uint64 = 2
+			for i := uint64(0); i < 3; i++ {
+				index++
+				res := apply(t, f, index, AppendRequestType, &api.ProduceRequest{
+					Record: &api.Record{Value: []byte("hello world")},
+				})
+				require.Equal(t, i, res.(*api.ProduceResponse).Offset)
+				if f.commands != nil && i == 0 {
+					index++
+					apply(t, f, index, 1, &api.Record{Offset: 5})
+					index++
+					apply(t, f, index, 1, &api.Record{Offset: 2})
+				}
+			}
+
+			snap, err := f.Snapshot()
+			require.NoError(t, err)
+			sink := &sink{}
+			require.NoError(t, snap.Persist(sink))
+
+			restored := newFSM(commands())
+			require.NoError(t, restored.Restore(io.NopCloser(&sink.buf)))
+
+			for off := uint64(0); off < 3; off++ {
+				want, _, err := f.index(off)
+				require.NoError(t, err)
+				got, _, err := restored.index(off)
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+			}
+			_, _, err = restored.index(3)
+			require.Equal(t, api.ErrOffsetOutOfRange{Offset: 3}, err)
+			if f.commands != nil {
+				require.Equal(t, uint64(7), restored.commands[1].(*counter).total)
+			}
+		})
+	}
+}
+
+func TestFSMIndex(t *testing.T) {
+	f := newFSM(nil)
+	_, _, err := f.index(0)
+	require.Error(t, err)
+
+	// single records are encoded like they were before appends shared
+	// entries
+	for _, index := range []uint64{2, 3, 4, 6} {
+		apply(t, f, index, AppendRequestType, &api.ProduceRequest{
+			Record: &api.Record{},
+		})
+	}
+	for _, index := range []uint64{7, 8, 10} {
+		res := apply(t, f, index, AppendRequestType, &api.Records{
+			Records: []*api.Record{{}, {}},
+		})
+		require.Equal(t, f.nextOffset-2, res.(*api.ProduceResponse).Offset)
+	}
+	require.Equal(t, 4, len(f.runs))
+
+	type location struct{ index, pos uint64 }
+	for off, want := range []location{
+		{2, 0}, {3, 0}, {4, 0}, {6, 0},
+		{7, 0}, {7, 1}, {8, 0}, {8, 1}, {10, 0}, {10, 1},
+	} {
+		index, pos, err := f.index(uint64(off))
+		require.NoError(t, err)
+		require.Equal(t, want, location{index, pos})
+	}
+}
+
+func TestFSMApplyBatch(t *testing.T) {
+	f := newFSM(nil)
+	b, err := proto.Marshal(&api.Records{Records: []*api.Record{{}}})
+	require.NoError(t, err)
+	data := append([]byte{byte(AppendRequestType)}, b...)
+
+	res := f.ApplyBatch([]*raft.Log{
+		{Index: 1, Type: raft.LogConfiguration},
+		{Index: 2, Type: raft.LogCommand, Data: data},
+		{Index: 3, Type: raft.LogCommand, Data: data},
+	})
+	require.Equal(t, 3, len(res))
+	require.Nil(t, res[0])
+	require.Equal(t, uint64(1), res[2].(*api.ProduceResponse).Offset)
+	index, _, err := f.index(1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), index)
+}
+
+func TestFSMUnknownRequestType(t *testing.T) {
+	f := newFSM(nil)
+
+	res := f.Apply(&raft.Log{Index: 1, Data: []byte{9}})
+	err, ok := res.(error)
+	require.True(t, ok)
+	require.True(t, errors.Is(err, ErrUnknownRequestType))
+}
+
+func TestSnapshotPredatingRaftLogRecords(t *testing.T) {
+	for scenario, withStates := range map[string]bool{
+		"records only":              false,
+		"records and command state": true,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			old, err := NewLog(t.TempDir(), Config{})
+			require.NoError(t, err)
+			defer old.Close()
+			for i := 0; i < 3; i++ {
+				_, err = old.Append(&api.Record{Value: []byte("hello world")})
+				require.NoError(t, err)
+			}
+			var buf bytes.Buffer
+			if withStates {
+				require.NoError(t, binary.Write(&buf, enc, uint64(snapshotMarker)))
+			}
+			_, err = io.Copy(&buf, old.Reader())
+			require.NoError(t, err)
+			if withStates {
+				require.NoError(t, binary.Write(&buf, enc, uint64(snapshotMarker)))
+				buf.Write([]byte{1})
+				require.NoError(t, binary.Write(&buf, enc, uint64(8)))
+				require.NoError(t, binary.Write(&buf, enc, uint64(7)))
+			}
+
+			f := newFSM(map[RequestType]Command{1: &counter{}})
+			f.legacyDir = filepath.Join(t.TempDir(), "log")
+			defer f.close()
+			require.NoError(t, f.Restore(io.NopCloser(&buf)))
+			if withStates {
+				require.Equal(t, uint64(7), f.commands[1].(*counter).total)
+			}
+
+			// the records applied after follow the snapshot's
+			res := apply(t, f, 10, AppendRequestType, &api.ProduceRequest{
+				Record: &api.Record{Value: []byte("hello world")},
+			})
+			require.Equal(t, uint64(3), res.(*api.ProduceResponse).Offset)
+
+			// and the snapshots taken after still hold the snapshot's
+			snap, err := f.Snapshot()
+			require.NoError(t, err)
+			sink := &sink{}
+			require.NoError(t, snap.Persist(sink))
+			restored := newFSM(map[RequestType]Command{1: &counter{}})
+			restored.legacyDir = filepath.Join(t.TempDir(), "log")
+			defer restored.close()
+			require.NoError(t, restored.Restore(io.NopCloser(&sink.buf)))
+
+			for _, f := range []*fsm{f, restored} {
+				lowest, next := f.offsets()
+				require.Equal(t, uint64(0), lowest)
+				require.Equal(t, uint64(4), next)
+				for off := uint64(0); off < 3; off++ {
+					record, ok, err := f.readLegacy(off)
+					require.NoError(t, err)
+					require.True(t, ok)
+					require.Equal(t, off, record.Offset)
+					require.Equal(t, []byte("hello world"), record.Value)
+				}
+				_, ok, err := f.readLegacy(3)
+				require.NoError(t, err)
+				require.False(t, ok)
+				index, _, err := f.index(3)
+				require.NoError(t, err)
+				require.Equal(t, uint64(10), index)
+			}
+
+			// a snapshot that can't be read back leaves the legacy records be
+			truncated := persist(t, restored)[:60]
+			require.Error(t, restored.Restore(io.NopCloser(bytes.NewReader(truncated))))
+			_, err = os.Stat(restored.legacyDir)
+			require.NoError(t, err)
+			record, ok, err := restored.readLegacy(2)
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, []byte("hello world"), record.Value)
+
+			// restoring a snapshot without legacy records removes them
+			require.NoError(t, restored.Restore(io.NopCloser(
+				bytes.NewReader(persist(t, newFSM(nil))),
+			)))
+			require.Nil(t, restored.legacy)
+			_, err = os.Stat(restored.legacyDir)
+			require.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestLogStoreDeleteRange(t *testing.T) {
+	c := Config{}
+	c.Segment.InitialOffset = 1
+	store, err := newLogStore(t.TempDir(), c)
+	require.NoError(t, err)
+	defer store.Close()
+	var applied uint64 = 2
+	store.applied = func() uint64 { return applied }
+
+	for i := uint64(1); i <= 4; i++ {
+		require.NoError(t, store.StoreLog(&raft.Log{Index: i, Term: 1}))
+	}
+	last, err := store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), last)
+
+	// compacting the committed entries keeps them
+	require.NoError(t, store.DeleteRange(1, 2))
+	first, err := store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), first)
+
+	// conflicting entries get replaced
+	require.NoError(t, store.DeleteRange(3, 4))
+	last, err = store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), last)
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 3, Term: 2}))
+	var entry raft.Log
+	require.NoError(t, store.GetLog(3, &entry))
+	require.Equal(t, uint64(2), entry.Term)
+
+	// after installing a snapshot raft stores the entries past it, the
+	// store starts over from them
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 7, Term: 2}))
+	first, err = store.FirstIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), first)
+	require.NoError(t, store.StoreLog(&raft.Log{Index: 8, Term: 2}))
+	last, err = store.LastIndex()
+	require.NoError(t, err)
+	require.Equal(t, uint64(8), last)
+}
+
+func apply(
+	t *testing.T,
+	f *fsm,
+	index uint64,
+	reqType RequestType,
+	req proto.Message,
+) interface{} {
+	t.Helper()
+	b, err := proto.Marshal(req)
+	require.NoError(t, err)
+	res := f.Apply(&raft.Log{
+		Index: index,
+		Data:  append([]byte{byte(reqType)}, b...),
+	})
+	if err, ok := res.(error); ok {
+		require.NoError(t, err)
+	}
+	return res
+}
+
+func persist(t *testing.T, f *fsm) []byte {
+	t.Helper()
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &sink{}
+	require.NoError(t, snap.Persist(sink))
+	return sink.buf.Bytes()
+}
+
+// counter adds up the offsets of the records it's given.
+type counter struct {
+	total uint64
+}
+
+func (c *counter) Apply(req []byte) interface{} {
+	var record api.Record
+	if err := proto.Unmarshal(req, &record); err != nil {
+		return err
+	}
+	c.total += record.Offset
+	return c.total
+}
+
+func (c *counter) Snapshot() ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, c.total)
+	return b, nil
+}
+
+func (c *counter) Restore(state []byte) error {
+	c.total = binary.BigEndian.Uint64(state)
+	return nil
+}
+
+// noState keeps no state so it isn't in snapshots.
+type noState struct{}
+
+func (noState) Apply([]byte) interface{} { return nil }
+
+type sink struct {
+	buf bytes.Buffer
+}
+
+func (s *sink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+func (s *sink) Close() error                { return nil }
+func (s *sink) ID() string                  { return "snapshot" }
+func (s *sink) Cancel() error               { return nil }
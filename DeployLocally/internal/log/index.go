@@ -74,10 +74,20 @@ func (i *index) Write(off uint32, pos uint64) error {
 	return nil
 }
 
+// truncate keeps the first n entries.
+func (i *index) truncate(n uint64) {
+	i.size = n * entWidth
+}
+
 func (i *index) Name() string {
 	return i.file.Name()
 }
 
+// Sync syncs the memory mapped entries to the file.
+func (i *index) Sync() error {
+	return i.mmap.Sync(gommap.MS_SYNC)
+}
+
 // NOTE: none of this is "safe" when considering the possibility of a power shortage
 // or anything that would finish the program execution without executing this method
 func (i *index) Close() error {
@@ -1,6 +1,8 @@
 package log
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
 	"path"
@@ -20,6 +22,8 @@ type Log struct {
 
 	activeSegment *segment
 	segments      []*segment
+
+	notifier appendNotifier
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -75,16 +79,55 @@ func (l *Log) setup() error {
 func (l *Log) Append(record *api.Record) (uint64, error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	off, err := l.append(record)
+	if err != nil {
+		return 0, err
+	}
+	l.notifier.notify()
+	return off, nil
+}
+
+// AppendBatch appends the records one after the other and returns the first
+// one's offset.
+func (l *Log) AppendBatch(records []*api.Record) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var first uint64
+	for i, record := range records {
+		off, err := l.append(record)
+		if err != nil {
+			return 0, err
+		}
+		if i == 0 {
+			first = off
+		}
+	}
+	l.notifier.notify()
+	return first, nil
+}
+
+func (l *Log) append(record *api.Record) (uint64, error) {
 	off, err := l.activeSegment.Append(record)
 	if err != nil {
 		return 0, err
 	}
 	if l.activeSegment.IsMaxed() {
+		// synced as it's rolled over, Sync only syncs the active segment
+		if err := l.activeSegment.Sync(); err != nil {
+			return 0, err
+		}
 		err = l.newSegment(off + 1)
 	}
 	return off, err
 }
 
+// Sync syncs the appended records to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.activeSegment.Sync()
+}
+
 func (l *Log) Read(off uint64) (*api.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -125,9 +168,22 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.segments = nil
 	return l.setup()
 }
 
+// Wait waits until the record at off is appended.
+func (l *Log) Wait(ctx context.Context, off uint64) error {
+	return l.notifier.wait(ctx, off, func() uint64 {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+		return l.activeSegment.nextOffset
+	})
+}
+
 func (l *Log) LowestOffset() (uint64, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -144,6 +200,55 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off - 1, nil
 }
 
+// GetLogInfo describes the log and each of its segments.
+func (l *Log) GetLogInfo() (*api.GetLogInfoResponse, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	res := &api.GetLogInfoResponse{
+		LowestOffset: l.segments[0].baseOffset,
+		SegmentCount: uint64(len(l.segments)),
+	}
+	if next := l.segments[len(l.segments)-1].nextOffset; next > 0 {
+		res.HighestOffset = next - 1
+	}
+	for _, s := range l.segments {
+		segment := &api.Segment{
+			BaseOffset: s.baseOffset,
+			NextOffset: s.nextOffset,
+			StoreSize:  s.store.size,
+			IndexSize:  s.index.size,
+		}
+		res.TotalBytes += segment.StoreSize + segment.IndexSize
+		res.Segments = append(res.Segments, segment)
+	}
+	return res, nil
+}
+
+// StartOffset resolves where consuming from the start position begins,
+// latest being right after the highest record.
+func (l *Log) StartOffset(start api.Start, offset uint64) (uint64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return startOffset(
+		start,
+		offset,
+		l.segments[0].baseOffset,
+		l.segments[len(l.segments)-1].nextOffset,
+	)
+}
+
+func startOffset(start api.Start, offset, lowest, next uint64) (uint64, error) {
+	switch start {
+	case api.Start_START_OFFSET:
+		return offset, nil
+	case api.Start_START_EARLIEST:
+		return lowest, nil
+	case api.Start_START_LATEST:
+		return next, nil
+	}
+	return 0, fmt.Errorf("unknown start: %v", start)
+}
+
 func (l *Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -159,6 +264,42 @@ func (l *Log) Truncate(lowest uint64) error {
 		segments = append(segments, s)
 	}
 	l.segments = segments
+	// keep offsets growing from where the truncated segments ended
+	if len(l.segments) == 0 {
+		return l.newSegment(lowest + 1)
+	}
+	return nil
+}
+
+// TruncateFrom removes the records from off onward, so the next record
+// appended gets off.
+func (l *Log) TruncateFrom(off uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var segments []*segment
+	for _, s := range l.segments {
+		if s.baseOffset >= off {
+			if err := s.Remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		if off < s.nextOffset {
+			if err := s.truncate(off); err != nil {
+				return err
+			}
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	if len(l.segments) == 0 {
+		return l.newSegment(off)
+	}
+	l.activeSegment = l.segments[len(l.segments)-1]
+	if l.activeSegment.IsMaxed() {
+		return l.newSegment(l.activeSegment.nextOffset)
+	}
 	return nil
 }
 
@@ -174,13 +315,16 @@ func (l *Log) Reader() io.Reader {
 	return io.MultiReader(readers...)
 }
 
+// originReader reads the store from its start. The store isn't embedded so
+// io.Copy can't use the store's file WriteTo, which reads from the file's
+// current offset.
 type originReader struct {
-	*store
-	off int64
+	store *store
+	off   int64
 }
 
 func (o *originReader) Read(p []byte) (int, error) {
-	n, err := o.ReadAt(p, o.off)
+	n, err := o.store.ReadAt(p, o.off)
 	o.off += int64(n)
 	return n, err
 }
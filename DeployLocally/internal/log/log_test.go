@@ -1,8 +1,10 @@
 package log
 
 import (
+	"context"
 	"io"
 	"testing"
+	"time"
 
 	api "github.com/madalosso/proglog/api/v1"
 	"github.com/stretchr/testify/require"
@@ -17,6 +19,10 @@ func TestLog(t *testing.T) {
 		"init with  existing segments":      testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"truncate every segment":            testTruncateAll,
+		"truncate from":                     testTruncateFrom,
+		"wait for append":                   testWait,
+		"start offset":                      testStartOffset,
 	}
 
 	for scenario, fn := range testMap {
@@ -116,3 +122,127 @@ func testTruncate(t *testing.T, log *Log) {
 	require.Error(t, err)
 
 }
+
+func testTruncateAll(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	err := log.Truncate(2)
+	require.NoError(t, err)
+
+	off, err := log.Append(append)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), off)
+
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), lowest)
+}
+
+func testTruncateFrom(t *testing.T, log *Log) {
+	append := &api.Record{
+		Value: []byte("hello world"),
+	}
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(append)
+		require.NoError(t, err)
+	}
+
+	err := log.TruncateFrom(1)
+	require.NoError(t, err)
+
+	_, err = log.Read(1)
+	require.Error(t, err)
+	read, err := log.Read(0)
+	require.NoError(t, err)
+	require.Equal(t, append.Value, read.Value)
+
+	off, err := log.Append(&api.Record{Value: []byte("replaced")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	read, err = log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("replaced"), read.Value)
+}
+
+func TestTruncateFromWithinSegment(t *testing.T) {
+	c := Config{}
+	c.Segment.MaxStoreBytes = 1024
+	log, err := NewLog(t.TempDir(), c)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.TruncateFrom(1))
+
+	highest, err := log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), highest)
+
+	off, err := log.Append(&api.Record{Value: []byte("replaced")})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), off)
+	require.NoError(t, log.Close())
+
+	// the truncated store and index are what's left after a restart
+	log, err = NewLog(log.Dir, c)
+	require.NoError(t, err)
+	defer log.Close()
+	highest, err = log.HighestOffset()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), highest)
+	read, err := log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("replaced"), read.Value)
+}
+
+func testWait(t *testing.T, log *Log) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, log.Wait(ctx, 0))
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = log.Append(&api.Record{Value: []byte("hello world")})
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, log.Wait(ctx, 0))
+
+	// records already appended don't wait
+	require.NoError(t, log.Wait(context.Background(), 0))
+}
+
+func testStartOffset(t *testing.T, log *Log) {
+	off, err := log.StartOffset(api.Start_START_LATEST, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), off)
+
+	for i := 0; i < 3; i++ {
+		_, err := log.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+	require.NoError(t, log.Truncate(1))
+	lowest, err := log.LowestOffset()
+	require.NoError(t, err)
+	require.NotEqual(t, uint64(0), lowest)
+
+	for start, want := range map[api.Start]uint64{
+		api.Start_START_OFFSET:   7,
+		api.Start_START_EARLIEST: lowest,
+		api.Start_START_LATEST:   3,
+	} {
+		off, err := log.StartOffset(start, 7)
+		require.NoError(t, err)
+		require.Equal(t, want, off)
+	}
+	_, err = log.StartOffset(api.Start(42), 0)
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+// Package logtest sets up distributed logs for the tests of the log and of
+// the servers serving it.
+package logtest
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/madalosso/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+)
+
+// NewDistributedLog sets up a distributed log serving raft on the port, with
+// timeouts short enough for tests. The fns adjust its config, and it's
+// closed once the test ends.
+func NewDistributedLog(
+	t testing.TB,
+	id string,
+	port int,
+	bootstrap bool,
+	fns ...func(*log.Config),
+) *log.DistributedLog {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	require.NoError(t, err)
+
+	config := log.Config{}
+	config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
+	config.Raft.LocalID = raft.ServerID(id)
+	config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+	config.Raft.ElectionTimeout = 50 * time.Millisecond
+	config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+	config.Raft.CommitTimeout = 5 * time.Millisecond
+	config.Raft.BindAddr = ln.Addr().String()
+	config.Raft.Bootstrap = bootstrap
+	for _, fn := range fns {
+		fn(&config)
+	}
+
+	l, err := log.NewDistributedLog(dataDir, config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = l.Close()
+	})
+	return l
+}
+
+// NewLeader sets up a distributed log leading a cluster of its own.
+func NewLeader(t testing.TB) *log.DistributedLog {
+	t.Helper()
+	l := NewDistributedLog(t, "0", dynaport.Get(1)[0], true)
+	require.NoError(t, l.WaitForLeader(3*time.Second))
+	return l
+}
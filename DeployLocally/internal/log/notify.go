@@ -0,0 +1,51 @@
+package log
+
+import (
+	"context"
+	"sync"
+)
+
+// appendNotifier wakes the readers waiting for records to be appended. The
+// channel is closed on each append and a new one made for the next waiters.
+type appendNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func (n *appendNotifier) appended() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+func (n *appendNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// wait waits until next, the offset the next record gets, is past off. The
+// channel is taken before checking so an append in between isn't missed.
+func (n *appendNotifier) wait(
+	ctx context.Context,
+	off uint64,
+	next func() uint64,
+) error {
+	for {
+		appended := n.appended()
+		if off < next() {
+			return nil
+		}
+		select {
+		case <-appended:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
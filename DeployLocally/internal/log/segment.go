@@ -80,12 +80,29 @@ func (s *segment) Read(off uint64) (*api.Record, error) {
 	return record, err
 }
 
+// truncate drops the records from off onward.
+func (s *segment) truncate(off uint64) error {
+	_, pos, err := s.index.Read(int64(off - s.baseOffset))
+	if err != nil {
+		return err
+	}
+	if err := s.store.truncate(pos); err != nil {
+		return err
+	}
+	s.index.truncate(off - s.baseOffset)
+	s.nextOffset = off
+	return nil
+}
+
 func (s *segment) IsMaxed() bool {
 	return s.store.size >= s.config.Segment.MaxStoreBytes ||
 		s.index.size >= s.config.Segment.MaxIndexBytes
 }
 
 func (s *segment) Remove() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
 	if err := os.Remove(s.index.Name()); err != nil {
 		return err
 	}
@@ -95,6 +112,14 @@ func (s *segment) Remove() error {
 	return nil
 }
 
+// Sync syncs the segment's records and their index entries to disk.
+func (s *segment) Sync() error {
+	if err := s.store.Sync(); err != nil {
+		return err
+	}
+	return s.index.Sync()
+}
+
 func (s *segment) Close() error {
 	if err := s.index.Close(); err != nil {
 		return err
@@ -0,0 +1,136 @@
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// raft doesn't expose how far each follower got, so the transport records,
+// while this node is leader, the highest index every follower acknowledged
+// and when it last heard back from them. What it recorded is for the term it
+// was recorded in: a node leading again starts over, the followers' logs may
+// have been truncated by the leaders in between.
+type trackingTransport struct {
+	*raft.NetworkTransport
+
+	mu       sync.Mutex
+	term     uint64
+	matches  map[raft.ServerID]uint64
+	contacts map[raft.ServerID]time.Time
+}
+
+func newTrackingTransport(trans *raft.NetworkTransport) *trackingTransport {
+	return &trackingTransport{
+		NetworkTransport: trans,
+		matches:          make(map[raft.ServerID]uint64),
+		contacts:         make(map[raft.ServerID]time.Time),
+	}
+}
+
+func (t *trackingTransport) AppendEntries(
+	id raft.ServerID,
+	target raft.ServerAddress,
+	args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse,
+) error {
+	if err := t.NetworkTransport.AppendEntries(id, target, args, resp); err != nil {
+		return err
+	}
+	t.observe(id, args, resp)
+	return nil
+}
+
+func (t *trackingTransport) AppendEntriesPipeline(
+	id raft.ServerID,
+	target raft.ServerAddress,
+) (raft.AppendPipeline, error) {
+	pipeline, err := t.NetworkTransport.AppendEntriesPipeline(id, target)
+	if err != nil {
+		return nil, err
+	}
+	p := &trackingPipeline{
+		AppendPipeline: pipeline,
+		id:             id,
+		transport:      t,
+		doneCh:         make(chan raft.AppendFuture),
+		shutdownCh:     make(chan struct{}),
+	}
+	go p.forward()
+	return p, nil
+}
+
+func (t *trackingTransport) observe(
+	id raft.ServerID,
+	args *raft.AppendEntriesRequest,
+	resp *raft.AppendEntriesResponse,
+) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case args.Term < t.term:
+		// a response to the last time this node led
+		return
+	case args.Term > t.term:
+		t.term = args.Term
+		t.matches = make(map[raft.ServerID]uint64)
+		t.contacts = make(map[raft.ServerID]time.Time)
+	}
+	t.contacts[id] = time.Now()
+	if !resp.Success {
+		return
+	}
+	index := args.PrevLogEntry + uint64(len(args.Entries))
+	if index > t.matches[id] {
+		t.matches[id] = index
+	}
+}
+
+func (t *trackingTransport) match(id raft.ServerID) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.matches[id]
+}
+
+func (t *trackingTransport) lastContact(id raft.ServerID) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.contacts[id]
+}
+
+type trackingPipeline struct {
+	raft.AppendPipeline
+	id        raft.ServerID
+	transport *trackingTransport
+
+	doneCh     chan raft.AppendFuture
+	shutdownCh chan struct{}
+}
+
+func (p *trackingPipeline) forward() {
+	for {
+		select {
+		case future := <-p.AppendPipeline.Consumer():
+			if future.Error() == nil {
+				p.transport.observe(p.id, future.Request(), future.Response())
+			}
+			select {
+			case p.doneCh <- future:
+			case <-p.shutdownCh:
+				return
+			}
+		case <-p.shutdownCh:
+			return
+		}
+	}
+}
+
+func (p *trackingPipeline) Consumer() <-chan raft.AppendFuture {
+	return p.doneCh
+}
+
+func (p *trackingPipeline) Close() error {
+	close(p.shutdownCh)
+	return p.AppendPipeline.Close()
+}
@@ -0,0 +1,27 @@
+package log
+
+import (
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackingTransportTerms(t *testing.T) {
+	transport := newTrackingTransport(nil)
+	success := &raft.AppendEntriesResponse{Success: true}
+
+	transport.observe("1", &raft.AppendEntriesRequest{Term: 2, PrevLogEntry: 10}, success)
+	require.Equal(t, uint64(10), transport.match("1"))
+	require.False(t, transport.lastContact("1").IsZero())
+
+	// leading again, what the followers acknowledged before doesn't count
+	transport.observe("2", &raft.AppendEntriesRequest{Term: 4, PrevLogEntry: 5}, success)
+	require.Equal(t, uint64(0), transport.match("1"))
+	require.True(t, transport.lastContact("1").IsZero())
+	require.Equal(t, uint64(5), transport.match("2"))
+
+	// late responses from the previous term are ignored
+	transport.observe("1", &raft.AppendEntriesRequest{Term: 2, PrevLogEntry: 12}, success)
+	require.Equal(t, uint64(0), transport.match("1"))
+}
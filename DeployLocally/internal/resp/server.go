@@ -0,0 +1,347 @@
+// Package resp serves the log as a Redis stream over RESP, Redis' protocol,
+// so Redis clients can add to and read from it with XADD, XRANGE, XREAD,
+// XLEN and XINFO STREAM. The log is the one stream there is. Offsets show up
+// as stream IDs, offset n being the ID n-1, as Redis streams have no 0-0
+// entry. There are no consumer groups or trimming, the log's retention
+// truncates the stream.
+//
+// Clients authenticate with their certificates or with AUTH, either way as
+// the subjects the ACL policy knows.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/connserver"
+	"go.uber.org/zap"
+)
+
+// Log is the log the Redis clients add to and read from.
+type Log interface {
+	Append(*api.Record) (uint64, error)
+	Read(uint64) (*api.Record, error)
+	StartOffset(api.Start, uint64) (uint64, error)
+	Wait(ctx context.Context, offset uint64) error
+}
+
+type Authorizer interface {
+	Authorize(subject, object, action string) error
+}
+
+// Authenticator checks the passwords clients AUTH with, their usernames
+// being their subjects.
+type Authenticator interface {
+	Authenticate(subject, password string) error
+}
+
+type Config struct {
+	Log        Log
+	Authorizer Authorizer
+	// CertAuthenticator tells who clients are by their certificates, by
+	// their common name by default.
+	CertAuthenticator auth.CertAuthenticator
+	// Authenticator lets clients AUTH, only certificates authenticate them
+	// when it's nil.
+	Authenticator Authenticator
+	// Stream is the key the log goes by, "proglog" when empty.
+	Stream string
+	// MaxBlock caps how long XREAD blocks for entries yet to be added, 30s
+	// when 0.
+	MaxBlock time.Duration
+	// MaxEntries caps the entries an XRANGE or XREAD reply carries, whatever
+	// their COUNT, 1000 when 0.
+	MaxEntries int
+}
+
+const (
+	defaultStream     = "proglog"
+	defaultMaxBlock   = 30 * time.Second
+	defaultMaxEntries = 1000
+	// defaultUser is who AUTH authenticates when given only a password.
+	defaultUser = "default"
+
+	produceAction  = "produce"
+	consumeAction  = "consume"
+	describeAction = "describe"
+)
+
+type Server struct {
+	Config
+	*connserver.Server
+}
+
+func NewServer(config Config) (*Server, error) {
+	if config.Log == nil {
+		return nil, fmt.Errorf("resp server needs a log")
+	}
+	if config.Authorizer == nil {
+		return nil, fmt.Errorf("resp server needs an authorizer")
+	}
+	if config.Stream == "" {
+		config.Stream = defaultStream
+	}
+	if config.MaxBlock == 0 {
+		config.MaxBlock = defaultMaxBlock
+	}
+	if config.MaxEntries == 0 {
+		config.MaxEntries = defaultMaxEntries
+	}
+	s := &Server{Config: config}
+	s.Server = connserver.New(s.serveConn)
+	return s, nil
+}
+
+// conn is a client's connection, subject is who the client authenticated
+// as, if it has.
+type conn struct {
+	*Server
+	subject string
+	w       *writer
+}
+
+// serveConn answers the connection's commands in order, flushing the replies
+// once it has answered the commands pipelined so far.
+func (s *Server) serveConn(netConn net.Conn) {
+	logger := zap.L().Named("resp")
+	subject, err := connserver.Subject(netConn, s.CertAuthenticator)
+	if err != nil {
+		logger.Debug("handshake failed", zap.Error(err))
+		return
+	}
+	r := bufio.NewReader(netConn)
+	c := &conn{Server: s, subject: subject, w: newWriter(netConn)}
+	for {
+		args, err := readCommand(r)
+		var protoErr protocolError
+		if errors.As(err, &protoErr) {
+			c.w.error("ERR Protocol error: " + string(protoErr))
+			_ = c.w.Flush()
+			return
+		} else if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Debug("failed to read command", zap.Error(err))
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if quit := c.handle(args); quit {
+			_ = c.w.Flush()
+			return
+		}
+		if r.Buffered() > 0 {
+			continue
+		}
+		if err := c.w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// command answers its arguments, the command's name excluded. Arity is how
+// many arguments the command takes, at least -arity when it's negative,
+// counting its name like Redis does.
+type command struct {
+	handler func(c *conn, args [][]byte) error
+	arity   int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"ping":   {(*conn).ping, -1},
+		"echo":   {(*conn).echo, 2},
+		"quit":   {(*conn).quit, 1},
+		"auth":   {(*conn).auth, -2},
+		"hello":  {(*conn).hello, -1},
+		"client": {(*conn).client, -2},
+		"select": {(*conn).selectDB, 2},
+		"xadd":   {(*conn).xadd, -5},
+		"xrange": {(*conn).xrange, -4},
+		"xread":  {(*conn).xread, -4},
+		"xlen":   {(*conn).xlen, 2},
+		"xinfo":  {(*conn).xinfo, -2},
+	}
+}
+
+// replyError is an error the client gets as it is, its first word being its
+// code.
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+func errSyntax() error {
+	return replyError("ERR syntax error")
+}
+
+func errArgs(name string) error {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+}
+
+// handle answers the command, it reports whether the client quit.
+func (c *conn) handle(args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	var err error
+	switch {
+	case !ok:
+		err = replyError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	case (cmd.arity > 0 && len(args) != cmd.arity) ||
+		(cmd.arity < 0 && len(args) < -cmd.arity):
+		err = errArgs(name)
+	default:
+		err = cmd.handler(c, args[1:])
+	}
+	var reply replyError
+	if errors.As(err, &reply) {
+		c.w.error(string(reply))
+	} else if err != nil {
+		zap.L().Named("resp").Error("command failed", zap.String("command", name), zap.Error(err))
+		c.w.error("ERR " + err.Error())
+	}
+	return name == "quit"
+}
+
+// authorize checks the client may act on the stream, which the ACLs know as
+// topics/<key>. Unauthenticated clients aren't allowed anything.
+func (c *conn) authorize(name, key, action string) error {
+	if c.subject == "" {
+		return replyError("NOAUTH Authentication required.")
+	}
+	if err := c.Authorizer.Authorize(c.subject, "topics/"+key, action); err != nil {
+		return replyError(fmt.Sprintf(
+			"NOPERM User %s has no permissions to run the '%s' command", c.subject, name,
+		))
+	}
+	return nil
+}
+
+func (c *conn) ping(args [][]byte) error {
+	switch len(args) {
+	case 0:
+		c.w.simple("PONG")
+	case 1:
+		c.w.bulk(args[0])
+	default:
+		return errArgs("ping")
+	}
+	return nil
+}
+
+func (c *conn) echo(args [][]byte) error {
+	c.w.bulk(args[0])
+	return nil
+}
+
+func (c *conn) quit([][]byte) error {
+	c.w.simple("OK")
+	return nil
+}
+
+// auth authenticates the client as the subject, the default user when only
+// given a password.
+func (c *conn) auth(args [][]byte) error {
+	subject, password := defaultUser, ""
+	switch len(args) {
+	case 1:
+		password = string(args[0])
+	case 2:
+		subject, password = string(args[0]), string(args[1])
+	default:
+		return errSyntax()
+	}
+	if err := c.authenticate(subject, password); err != nil {
+		return err
+	}
+	c.w.simple("OK")
+	return nil
+}
+
+func (c *conn) authenticate(subject, password string) error {
+	if c.Authenticator == nil ||
+		c.Authenticator.Authenticate(subject, password) != nil {
+		return replyError(
+			"WRONGPASS invalid username-password pair or user is disabled.",
+		)
+	}
+	c.subject = subject
+	return nil
+}
+
+// hello answers like Redis does to RESP2 clients, RESP3 isn't supported.
+func (c *conn) hello(args [][]byte) error {
+	if len(args) > 0 {
+		if string(args[0]) != "2" {
+			return replyError("NOPROTO unsupported protocol version")
+		}
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		switch strings.ToLower(string(args[0])) {
+		case "auth":
+			if len(args) < 3 {
+				return errSyntax()
+			}
+			if err := c.authenticate(string(args[1]), string(args[2])); err != nil {
+				return err
+			}
+			args = args[3:]
+		case "setname":
+			if len(args) < 2 {
+				return errSyntax()
+			}
+			args = args[2:]
+		default:
+			return errSyntax()
+		}
+	}
+	c.w.array(14)
+	c.w.bulkString("server")
+	c.w.bulkString("redis")
+	c.w.bulkString("version")
+	c.w.bulkString("7.0.0")
+	c.w.bulkString("proto")
+	c.w.integer(2)
+	c.w.bulkString("id")
+	c.w.integer(0)
+	c.w.bulkString("mode")
+	c.w.bulkString("standalone")
+	c.w.bulkString("role")
+	c.w.bulkString("master")
+	c.w.bulkString("modules")
+	c.w.array(0)
+	return nil
+}
+
+// client accepts the connection's name and library, it doesn't keep them.
+func (c *conn) client(args [][]byte) error {
+	switch strings.ToLower(string(args[0])) {
+	case "setname", "setinfo":
+		c.w.simple("OK")
+		return nil
+	default:
+		return replyError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0]))
+	}
+}
+
+// selectDB only selects database 0, the stream's.
+func (c *conn) selectDB(args [][]byte) error {
+	if string(args[0]) != "0" {
+		return replyError("ERR DB index is out of range")
+	}
+	c.w.simple("OK")
+	return nil
+}
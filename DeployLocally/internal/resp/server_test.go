@@ -0,0 +1,191 @@
+package resp_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/config"
+	"github.com/madalosso/proglog/internal/log"
+	"github.com/madalosso/proglog/internal/log/logtest"
+	"github.com/madalosso/proglog/internal/resp"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const stream = "proglog"
+
+func TestServer(t *testing.T) {
+	l := logtest.NewLeader(t)
+	tlsAddr, addr := setupServer(t, l)
+	ctx := context.Background()
+
+	root := setupClient(t, &redis.Options{Addr: tlsAddr, TLSConfig: clientTLSConfig(t)})
+	for i := 0; i < 2; i++ {
+		id, err := root.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			Values: []string{"n", fmt.Sprint(i)},
+		}).Result()
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("%d-1", i), id)
+	}
+	// records appended through other APIs show their value
+	_, err := l.Append(&api.Record{Value: []byte("grpc")})
+	require.NoError(t, err)
+
+	// replies carry up to MaxEntries entries, the rest are read after them
+	messages, err := root.XRange(ctx, stream, "-", "+").Result()
+	require.NoError(t, err)
+	require.Equal(t, []redis.XMessage{
+		{ID: "0-1", Values: map[string]interface{}{"n": "0"}},
+		{ID: "1-1", Values: map[string]interface{}{"n": "1"}},
+	}, messages)
+	messages, err = root.XRange(ctx, stream, "(1-1", "+").Result()
+	require.NoError(t, err)
+	require.Equal(t, []redis.XMessage{
+		{ID: "2-1", Values: map[string]interface{}{"value": "grpc"}},
+	}, messages)
+
+	messages, err = root.XRangeN(ctx, stream, "(0-1", "+", 1).Result()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "1-1", messages[0].ID)
+
+	length, err := root.XLen(ctx, stream).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), length)
+
+	info, err := root.XInfoStream(ctx, stream).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(3), info.Length)
+	require.Equal(t, "2-1", info.LastGeneratedID)
+	require.Equal(t, "0-1", info.FirstEntry.ID)
+	require.Equal(t, "2-1", info.LastEntry.ID)
+
+	// reading from the last ID has nothing to read until there's more
+	_, err = root.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{stream, "2-1"},
+		Block:   -1,
+	}).Result()
+	require.Equal(t, redis.Nil, err)
+
+	read := make(chan *redis.XStreamSliceCmd)
+	go func() {
+		read <- root.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{stream, "$"},
+			Block:   5 * time.Second,
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	adder := setupClient(t, &redis.Options{Addr: tlsAddr, TLSConfig: clientTLSConfig(t)})
+	_, err = adder.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: []string{"n", "3"}}).Result()
+	require.NoError(t, err)
+	select {
+	case cmd := <-read:
+		streams, err := cmd.Result()
+		require.NoError(t, err)
+		require.Equal(t, []redis.XStream{{
+			Stream:   stream,
+			Messages: []redis.XMessage{{ID: "3-1", Values: map[string]interface{}{"n": "3"}}},
+		}}, streams)
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked read didn't get the added entry")
+	}
+
+	// without certificates, clients authenticate with passwords
+	anonymous := setupClient(t, &redis.Options{Addr: addr})
+	_, err = anonymous.XLen(ctx, stream).Result()
+	require.ErrorContains(t, err, "NOAUTH")
+
+	password := setupClient(t, &redis.Options{Addr: addr, Username: "root", Password: "secret"})
+	length, err = password.XLen(ctx, stream).Result()
+	require.NoError(t, err)
+	require.Equal(t, int64(4), length)
+
+	nobody := setupClient(t, &redis.Options{Addr: addr, Username: "nobody", Password: "secret"})
+	_, err = nobody.XAdd(ctx, &redis.XAddArgs{Stream: stream, Values: []string{"n", "4"}}).Result()
+	require.ErrorContains(t, err, "NOPERM")
+
+	wrong := setupClient(t, &redis.Options{Addr: addr, Username: "root", Password: "wrong"})
+	require.ErrorContains(t, wrong.Ping(ctx).Err(), "WRONGPASS")
+}
+
+// setupServer serves over TLS, where clients authenticate with their
+// certificates, and over plain TCP.
+func setupServer(t *testing.T, l *log.DistributedLog) (string, string) {
+	t.Helper()
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	passwordFile := filepath.Join(t.TempDir(), "passwords")
+	require.NoError(t, os.WriteFile(
+		passwordFile,
+		[]byte(fmt.Sprintf("root:%s\nnobody:%s\n", hash, hash)),
+		0600,
+	))
+	passwords, err := auth.LoadPasswords(passwordFile)
+	require.NoError(t, err)
+
+	srv, err := resp.NewServer(resp.Config{
+		Log:           l,
+		Authorizer:    auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Authenticator: passwords,
+		Stream:        stream,
+		MaxEntries:    2,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	var addrs []string
+	for _, wrap := range []func(net.Listener) net.Listener{
+		func(ln net.Listener) net.Listener { return tls.NewListener(ln, serverTLSConfig) },
+		func(ln net.Listener) net.Listener { return ln },
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addrs = append(addrs, ln.Addr().String())
+		go func() {
+			_ = srv.Serve(wrap(ln))
+		}()
+	}
+	return addrs[0], addrs[1]
+}
+
+func clientTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	return tlsConfig
+}
+
+func setupClient(t *testing.T, opts *redis.Options) *redis.Client {
+	t.Helper()
+	opts.MaxRetries = -1
+	client := redis.NewClient(opts)
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return client
+}
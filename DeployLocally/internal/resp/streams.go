@@ -0,0 +1,461 @@
+package resp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+)
+
+// valueField is the field holding the value of the records that have one,
+// which records added with XADD don't as their fields are their headers.
+const valueField = "value"
+
+func errInvalidID() error {
+	return replyError("ERR Invalid stream ID specified as stream command argument")
+}
+
+// streamID is the ID of the entry at the offset.
+func streamID(offset uint64) string {
+	return strconv.FormatUint(offset, 10) + "-1"
+}
+
+// parseID parses the ms-seq ID, seq being defaultSeq when left out.
+func parseID(s string, defaultSeq uint64) (ms, seq uint64, err error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	if ms, err = strconv.ParseUint(msPart, 10, 64); err != nil {
+		return 0, 0, errInvalidID()
+	}
+	if !hasSeq {
+		return ms, defaultSeq, nil
+	}
+	if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+		return 0, 0, errInvalidID()
+	}
+	return ms, seq, nil
+}
+
+// afterOffset is the first offset whose ID is after the given one.
+func afterOffset(id string) (uint64, error) {
+	ms, seq, err := parseID(id, 0)
+	if err != nil {
+		return 0, err
+	}
+	if seq == 0 {
+		return ms, nil
+	}
+	return ms + 1, nil
+}
+
+// rangeStart is the first offset within the range starting at the ID, it
+// excludes the ID when it starts with "(".
+func rangeStart(id string) (uint64, error) {
+	if id == "-" {
+		return 0, nil
+	}
+	if exclusive := strings.TrimPrefix(id, "("); exclusive != id {
+		return afterOffset(exclusive)
+	}
+	ms, seq, err := parseID(id, 0)
+	if err != nil {
+		return 0, err
+	}
+	if seq <= 1 {
+		return ms, nil
+	}
+	return ms + 1, nil
+}
+
+// rangeEnd is the offset past the range ending at the ID, it excludes the ID
+// when it starts with "(".
+func rangeEnd(id string) (uint64, error) {
+	if id == "+" {
+		return math.MaxUint64, nil
+	}
+	exclusive := strings.TrimPrefix(id, "(")
+	ms, seq, err := parseID(exclusive, math.MaxUint64)
+	if err != nil {
+		return 0, err
+	}
+	if (exclusive != id && seq > 1) || (exclusive == id && seq >= 1) {
+		return ms + 1, nil
+	}
+	return ms, nil
+}
+
+type entry struct {
+	offset uint64
+	record *api.Record
+}
+
+// readEntries reads up to count entries from the first offset on, stopping
+// before the end offset. Negative counts, and counts past MaxEntries, read
+// up to MaxEntries entries.
+func (c *conn) readEntries(first, end uint64, count int) ([]entry, error) {
+	if count < 0 || count > c.MaxEntries {
+		count = c.MaxEntries
+	}
+	lowest, err := c.Log.StartOffset(api.Start_START_EARLIEST, 0)
+	if err != nil {
+		return nil, err
+	}
+	next, err := c.Log.StartOffset(api.Start_START_LATEST, 0)
+	if err != nil {
+		return nil, err
+	}
+	if first < lowest {
+		first = lowest
+	}
+	if end > next {
+		end = next
+	}
+	var entries []entry
+	for offset := first; offset < end && count != 0; offset++ {
+		record, err := c.Log.Read(offset)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{offset: offset, record: record})
+		count--
+	}
+	return entries, nil
+}
+
+// writeEntry writes the entry as its ID and its fields, the record's headers
+// followed by its value if it has one.
+func (w *writer) writeEntry(e entry) {
+	w.array(2)
+	w.bulkString(streamID(e.offset))
+	fields := 2 * len(e.record.Headers)
+	if len(e.record.Value) > 0 {
+		fields += 2
+	}
+	w.array(fields)
+	for _, h := range e.record.Headers {
+		w.bulkString(h.Key)
+		w.bulk(h.Value)
+	}
+	if len(e.record.Value) > 0 {
+		w.bulkString(valueField)
+		w.bulk(e.record.Value)
+	}
+}
+
+func (w *writer) writeEntries(entries []entry) {
+	w.array(len(entries))
+	for _, e := range entries {
+		w.writeEntry(e)
+	}
+}
+
+// xadd appends the entry with its fields as the record's headers. The log
+// picks the entry's ID.
+func (c *conn) xadd(args [][]byte) error {
+	key := string(args[0])
+	args = args[1:]
+options:
+	for len(args) > 0 {
+		switch strings.ToLower(string(args[0])) {
+		case "nomkstream":
+			args = args[1:]
+		case "maxlen", "minid":
+			return replyError("ERR trimming isn't supported, the log's retention truncates the stream")
+		default:
+			break options
+		}
+	}
+	if len(args) < 3 || len(args)%2 == 0 {
+		return errArgs("xadd")
+	}
+	if string(args[0]) != "*" {
+		return replyError("ERR explicit IDs aren't supported, the log picks them")
+	}
+	if err := c.authorize("xadd", key, produceAction); err != nil {
+		return err
+	}
+	if key != c.Stream {
+		return replyError(fmt.Sprintf("ERR the '%s' stream is the only one", c.Stream))
+	}
+	record := &api.Record{TimestampMs: time.Now().UnixMilli()}
+	for i := 1; i < len(args); i += 2 {
+		record.Headers = append(record.Headers, &api.Header{
+			Key:   string(args[i]),
+			Value: args[i+1],
+		})
+	}
+	offset, err := c.Log.Append(record)
+	if err != nil {
+		return err
+	}
+	c.w.bulkString(streamID(offset))
+	return nil
+}
+
+// xrange replies with the entries between the IDs.
+func (c *conn) xrange(args [][]byte) error {
+	count := -1
+	switch len(args) {
+	case 3:
+	case 5:
+		if strings.ToLower(string(args[3])) != "count" {
+			return errSyntax()
+		}
+		n, err := strconv.Atoi(string(args[4]))
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		if count = n; count < 0 {
+			count = 0
+		}
+	default:
+		return errSyntax()
+	}
+	first, err := rangeStart(string(args[1]))
+	if err != nil {
+		return err
+	}
+	end, err := rangeEnd(string(args[2]))
+	if err != nil {
+		return err
+	}
+	if err := c.authorize("xrange", string(args[0]), consumeAction); err != nil {
+		return err
+	}
+	var entries []entry
+	if string(args[0]) == c.Stream {
+		if entries, err = c.readEntries(first, end, count); err != nil {
+			return err
+		}
+	}
+	c.w.writeEntries(entries)
+	return nil
+}
+
+// xread replies with the entries after the IDs, blocking until there are
+// some when asked to. Only the log's stream ever has any.
+func (c *conn) xread(args [][]byte) error {
+	count := -1
+	var block time.Duration
+	blocking := false
+	for {
+		if len(args) < 2 {
+			return errSyntax()
+		}
+		option := strings.ToLower(string(args[0]))
+		if option == "streams" {
+			args = args[1:]
+			break
+		}
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return replyError("ERR value is not an integer or out of range")
+		}
+		switch option {
+		case "count":
+			if count = int(n); count <= 0 {
+				count = -1
+			}
+		case "block":
+			if n < 0 {
+				return replyError("ERR timeout is negative")
+			}
+			block, blocking = time.Duration(n)*time.Millisecond, true
+		default:
+			return errSyntax()
+		}
+		args = args[2:]
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return replyError("ERR Unbalanced 'xread' list of streams: " +
+			"for each stream key an ID or '$' must be specified.")
+	}
+	keys, ids := args[:len(args)/2], args[len(args)/2:]
+
+	// offsets are where to read the log's stream from, for each time it's
+	// asked for
+	var offsets []uint64
+	for i, id := range ids {
+		var offset uint64
+		var err error
+		switch string(id) {
+		case ">":
+			return replyError("ERR The > ID can be specified only when calling " +
+				"XREADGROUP using the GROUP <group> <consumer> option.")
+		case "$":
+			offset, err = c.Log.StartOffset(api.Start_START_LATEST, 0)
+		default:
+			offset, err = afterOffset(string(id))
+		}
+		if err != nil {
+			return err
+		}
+		if string(keys[i]) == c.Stream {
+			offsets = append(offsets, offset)
+		}
+	}
+	for _, key := range keys {
+		if err := c.authorize("xread", string(key), consumeAction); err != nil {
+			return err
+		}
+	}
+
+	if block == 0 || block > c.MaxBlock {
+		block = c.MaxBlock
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), block)
+	defer cancel()
+	for {
+		read := make([][]entry, len(offsets))
+		var wait uint64
+		found := false
+		for i, offset := range offsets {
+			entries, err := c.readEntries(offset, math.MaxUint64, count)
+			if err != nil {
+				return err
+			}
+			read[i] = entries
+			found = found || len(entries) > 0
+			if i == 0 || offset < wait {
+				wait = offset
+			}
+		}
+		if found {
+			c.writeRead(read)
+			return nil
+		}
+		if !blocking {
+			c.w.nullArray()
+			return nil
+		}
+		if len(offsets) == 0 {
+			<-ctx.Done()
+			c.w.nullArray()
+			return nil
+		}
+		if err := c.Log.Wait(ctx, wait); err != nil {
+			c.w.nullArray()
+			return nil
+		}
+	}
+}
+
+// writeRead writes the streams XREAD found entries in.
+func (c *conn) writeRead(read [][]entry) {
+	streams := 0
+	for _, entries := range read {
+		if len(entries) > 0 {
+			streams++
+		}
+	}
+	c.w.array(streams)
+	for _, entries := range read {
+		if len(entries) == 0 {
+			continue
+		}
+		c.w.array(2)
+		c.w.bulkString(c.Stream)
+		c.w.writeEntries(entries)
+	}
+}
+
+// xlen replies with how many entries the stream has, the truncated ones
+// left out.
+func (c *conn) xlen(args [][]byte) error {
+	if err := c.authorize("xlen", string(args[0]), describeAction); err != nil {
+		return err
+	}
+	if string(args[0]) != c.Stream {
+		c.w.integer(0)
+		return nil
+	}
+	lowest, next, err := c.offsets()
+	if err != nil {
+		return err
+	}
+	c.w.integer(int64(next - lowest))
+	return nil
+}
+
+func (c *conn) offsets() (lowest, next uint64, err error) {
+	if lowest, err = c.Log.StartOffset(api.Start_START_EARLIEST, 0); err != nil {
+		return 0, 0, err
+	}
+	if next, err = c.Log.StartOffset(api.Start_START_LATEST, 0); err != nil {
+		return 0, 0, err
+	}
+	return lowest, next, nil
+}
+
+// xinfo answers XINFO STREAM, there are no groups or consumers to describe.
+func (c *conn) xinfo(args [][]byte) error {
+	if strings.ToLower(string(args[0])) != "stream" {
+		return replyError(fmt.Sprintf("ERR unknown subcommand '%s'", args[0]))
+	}
+	if len(args) != 2 {
+		if len(args) > 2 && strings.ToLower(string(args[2])) == "full" {
+			return replyError("ERR XINFO STREAM FULL isn't supported")
+		}
+		return errArgs("xinfo|stream")
+	}
+	if err := c.authorize("xinfo", string(args[1]), describeAction); err != nil {
+		return err
+	}
+	if string(args[1]) != c.Stream {
+		return replyError("ERR no such key")
+	}
+	lowest, next, err := c.offsets()
+	if err != nil {
+		return err
+	}
+	entries, err := c.readEntries(lowest, next, 1)
+	if err != nil {
+		return err
+	}
+	var last []entry
+	if next > lowest {
+		if last, err = c.readEntries(next-1, next, 1); err != nil {
+			return err
+		}
+	}
+	lastID, firstID := "0-0", "0-0"
+	if next > 0 {
+		lastID = streamID(next - 1)
+	}
+	if next > lowest {
+		firstID = streamID(lowest)
+	}
+
+	c.w.array(20)
+	c.w.bulkString("length")
+	c.w.integer(int64(next - lowest))
+	c.w.bulkString("radix-tree-keys")
+	c.w.integer(0)
+	c.w.bulkString("radix-tree-nodes")
+	c.w.integer(0)
+	c.w.bulkString("last-generated-id")
+	c.w.bulkString(lastID)
+	c.w.bulkString("max-deleted-entry-id")
+	c.w.bulkString("0-0")
+	c.w.bulkString("entries-added")
+	c.w.integer(int64(next))
+	c.w.bulkString("recorded-first-entry-id")
+	c.w.bulkString(firstID)
+	c.w.bulkString("groups")
+	c.w.integer(0)
+	for _, field := range []struct {
+		name    string
+		entries []entry
+	}{{"first-entry", entries}, {"last-entry", last}} {
+		c.w.bulkString(field.name)
+		if len(field.entries) == 0 {
+			c.w.nullArray()
+		} else {
+			c.w.writeEntry(field.entries[0])
+		}
+	}
+	return nil
+}
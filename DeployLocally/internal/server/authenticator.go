@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/madalosso/proglog/internal/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// authenticateCredentials tries the config's authenticators in order, the
+// first one knowing the credentials decides. Clients none of them know are
+// authenticated as the empty subject, which the Authorizer denies.
+func (c *Config) authenticateCredentials(ctx context.Context, creds auth.Credentials) (string, error) {
+	authenticators := c.Authenticators
+	if len(authenticators) == 0 {
+		authenticators = []auth.Authenticator{auth.CertAuthenticator{}}
+	}
+	for _, authenticator := range authenticators {
+		subject, err := authenticator.Authenticate(ctx, creds)
+		if errors.Is(err, auth.ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			if _, ok := status.FromError(err); !ok {
+				err = status.Error(codes.Unauthenticated, err.Error())
+			}
+			return "", err
+		}
+		return subject, nil
+	}
+	return "", nil
+}
+
+// authenticate puts the subject the gRPC client authenticates as in the
+// context, for the handlers to authorize.
+func (c *Config) authenticate(ctx context.Context) (context.Context, error) {
+	peer, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx, status.New(
+			codes.Unknown,
+			"couldn't find peer info",
+		).Err()
+	}
+	creds := auth.Credentials{}
+	if tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo); ok {
+		creds.VerifiedChains = tlsInfo.State.VerifiedChains
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			creds.Scheme, creds.Token = parseAuthorization(values[0])
+		}
+	}
+	subject, err := c.authenticateCredentials(ctx, creds)
+	if err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, subjectContextKey{}, subject), nil
+}
+
+// parseAuthorization splits the authorization header into its scheme and
+// token.
+func parseAuthorization(header string) (scheme, token string) {
+	scheme, token, _ = strings.Cut(strings.TrimSpace(header), " ")
+	return scheme, strings.TrimSpace(token)
+}
+
+// LoadAPIKeys reads the API keys clients authenticate with from a file of
+// "subject:hash" lines, hash being the hex SHA-256 of the key as made by
+// `printf %s "$key" | sha256sum`. Keys are random enough that a slow hash
+// like the passwords' would only slow every request down.
+func LoadAPIKeys(file string) (*APIKeys, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	subjects := make(map[[sha256.Size]byte]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		subject, hexHash, ok := strings.Cut(text, ":")
+		if !ok || subject == "" {
+			return nil, fmt.Errorf("%s:%d: expected subject:hash", file, line)
+		}
+		b, err := hex.DecodeString(strings.TrimSpace(hexHash))
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: expected a hex SHA-256 hash", file, line)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		subjects[hash] = subject
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &APIKeys{subjects: subjects}, nil
+}
+
+// APIKeys authenticates clients by the static API keys they send as bearer
+// tokens, or with the ApiKey scheme.
+type APIKeys struct {
+	subjects map[[sha256.Size]byte]string
+}
+
+var _ auth.Authenticator = (*APIKeys)(nil)
+
+func (k *APIKeys) Authenticate(_ context.Context, creds auth.Credentials) (string, error) {
+	if creds.Token == "" ||
+		(!strings.EqualFold(creds.Scheme, "Bearer") && !strings.EqualFold(creds.Scheme, "ApiKey")) {
+		return "", auth.ErrNoCredentials
+	}
+	// keyed by the keys' hashes, looking them up doesn't time the keys
+	subject, ok := k.subjects[sha256.Sum256([]byte(creds.Token))]
+	if !ok {
+		return "", errors.New("unknown API key")
+	}
+	return subject, nil
+}
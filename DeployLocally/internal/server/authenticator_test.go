@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAPIKeys(t *testing.T) {
+	keys := setupAPIKeys(t, map[string]string{"root": "root-key"})
+	ctx := context.Background()
+
+	for _, scheme := range []string{"Bearer", "apikey"} {
+		subject, err := keys.Authenticate(ctx, auth.Credentials{Scheme: scheme, Token: "root-key"})
+		require.NoError(t, err)
+		require.Equal(t, "root", subject)
+	}
+
+	_, err := keys.Authenticate(ctx, auth.Credentials{Scheme: "Bearer", Token: "wrong-key"})
+	require.Error(t, err)
+	require.NotEqual(t, auth.ErrNoCredentials, err)
+
+	_, err = keys.Authenticate(ctx, auth.Credentials{Scheme: "Basic", Token: "root-key"})
+	require.Equal(t, auth.ErrNoCredentials, err)
+
+	file := filepath.Join(t.TempDir(), "api-keys")
+	require.NoError(t, os.WriteFile(file, []byte("root:not-a-hash\n"), 0600))
+	_, err = LoadAPIKeys(file)
+	require.Error(t, err)
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	authenticator := setupJWTAuthenticator(t, key)
+	ctx := context.Background()
+
+	valid := jwt.Claims{
+		Issuer:   "https://issuer",
+		Audience: jwt.Audience{"proglog"},
+		Subject:  "root",
+		Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+	subject, err := authenticator.Authenticate(ctx, auth.Credentials{
+		Scheme: "Bearer",
+		Token:  signJWT(t, key, "key", valid),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "root", subject)
+
+	for name, token := range map[string]string{
+		"wrong issuer": signJWT(t, key, "key", withClaims(valid, func(c *jwt.Claims) {
+			c.Issuer = "https://other"
+		})),
+		"wrong audience": signJWT(t, key, "key", withClaims(valid, func(c *jwt.Claims) {
+			c.Audience = jwt.Audience{"other"}
+		})),
+		"expired": signJWT(t, key, "key", withClaims(valid, func(c *jwt.Claims) {
+			c.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		})),
+		"no expiry": signJWT(t, key, "key", withClaims(valid, func(c *jwt.Claims) {
+			c.Expiry = nil
+		})),
+		"no subject": signJWT(t, key, "key", withClaims(valid, func(c *jwt.Claims) {
+			c.Subject = ""
+		})),
+		"unknown key":     signJWT(t, key, "other", valid),
+		"wrong signature": signJWT(t, other, "key", valid),
+	} {
+		_, err := authenticator.Authenticate(ctx, auth.Credentials{Scheme: "Bearer", Token: token})
+		require.Error(t, err, name)
+		require.NotEqual(t, auth.ErrNoCredentials, err, name)
+	}
+
+	// API keys aren't JWTs
+	_, err = authenticator.Authenticate(ctx, auth.Credentials{Scheme: "Bearer", Token: "root-key"})
+	require.Equal(t, auth.ErrNoCredentials, err)
+}
+
+// TestTokenAuthentication checks clients' tokens decide who they are before
+// their certificates do, over gRPC and HTTP.
+func TestTokenAuthentication(t *testing.T) {
+	keys := setupAPIKeys(t, map[string]string{"root": "root-key", "nobody": "nobody-key"})
+	rootConn, nobodyConn, cfg, teardown := setupTest(t, func(c *Config) {
+		c.Authenticators = []auth.Authenticator{keys, auth.CertAuthenticator{}}
+	})
+	defer teardown()
+	root, nobody := api.NewLogClient(rootConn), api.NewLogClient(nobodyConn)
+	produce := &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}}
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err := nobody.Produce(withToken("root-key"), produce)
+	require.NoError(t, err)
+
+	_, err = root.Produce(withToken("nobody-key"), produce)
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = root.Produce(withToken("wrong-key"), produce)
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	handler, err := NewHTTPHandler(cfg)
+	require.NoError(t, err)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	for token, code := range map[string]int{
+		"root-key":  http.StatusOK,
+		"":          http.StatusForbidden,
+		"wrong-key": http.StatusUnauthorized,
+	} {
+		body := strings.NewReader(`{"record": {"value": "aGVsbG8="}}`)
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/v1/records", body)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, code, res.StatusCode, token)
+	}
+}
+
+func setupAPIKeys(t *testing.T, keys map[string]string) *APIKeys {
+	t.Helper()
+	var lines []string
+	for subject, key := range keys {
+		hash := sha256.Sum256([]byte(key))
+		lines = append(lines, fmt.Sprintf("%s:%s", subject, hex.EncodeToString(hash[:])))
+	}
+	file := filepath.Join(t.TempDir(), "api-keys")
+	content := "# subject:sha256\n" + strings.Join(lines, "\n") + "\n"
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	apiKeys, err := LoadAPIKeys(file)
+	require.NoError(t, err)
+	return apiKeys
+}
+
+func setupJWTAuthenticator(t *testing.T, key *ecdsa.PrivateKey) *JWTAuthenticator {
+	t.Helper()
+	b, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       key.Public(),
+		KeyID:     "key",
+		Algorithm: string(jose.ES256),
+		Use:       "sig",
+	}}})
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, b, 0600))
+	authenticator, err := NewJWTAuthenticator(JWTConfig{
+		JWKSFile: file,
+		Issuer:   "https://issuer",
+		Audience: "proglog",
+	})
+	require.NoError(t, err)
+	return authenticator
+}
+
+func signJWT(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.Claims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid),
+	)
+	require.NoError(t, err)
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func withClaims(claims jwt.Claims, fn func(*jwt.Claims)) jwt.Claims {
+	fn(&claims)
+	return claims
+}
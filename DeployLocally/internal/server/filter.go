@@ -0,0 +1,159 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	api "github.com/madalosso/proglog/api/v1"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	filterEnvOnce sync.Once
+	filterEnv     *cel.Env
+	filterEnvErr  error
+)
+
+// newFilterEnv declares the variables filters see of each record.
+func newFilterEnv() (*cel.Env, error) {
+	filterEnvOnce.Do(func() {
+		filterEnv, filterEnvErr = cel.NewEnv(cel.Declarations(
+			decls.NewVar("offset", decls.Uint),
+			decls.NewVar("key", decls.Bytes),
+			decls.NewVar("value", decls.Bytes),
+			decls.NewVar("headers", decls.NewMapType(decls.String, decls.Bytes)),
+			decls.NewVar("timestamp", decls.Timestamp),
+			decls.NewVar("json", decls.Dyn),
+		))
+	})
+	return filterEnv, filterEnvErr
+}
+
+// Filters are evaluated on every record consumed and cel-go doesn't bound
+// what evaluating them costs, so their size is bounded instead. Without
+// nested comprehensions, evaluating a filter takes at most as many steps as
+// it has nodes for each element of the record's headers or JSON value.
+const (
+	maxFilterLen   = 1024
+	maxFilterNodes = 128
+)
+
+// filter is a ConsumeRequest's compiled filter.
+type filter struct {
+	program cel.Program
+}
+
+// newFilter compiles the expression, there's no filter when it's empty.
+func newFilter(expr string) (*filter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	if len(expr) > maxFilterLen {
+		return nil, status.Errorf(codes.InvalidArgument, "filter is longer than %d bytes", maxFilterLen)
+	}
+	env, err := newFilterEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %s", issues.Err())
+	}
+	if err := checkFilterCost(ast.Expr()); err != nil {
+		return nil, err
+	}
+	if t := ast.ResultType(); !proto.Equal(t, decls.Bool) && !proto.Equal(t, decls.Dyn) {
+		return nil, status.Error(codes.InvalidArgument, "filter doesn't return a bool")
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid filter: %s", err)
+	}
+	return &filter{program: program}, nil
+}
+
+// checkFilterCost rejects the filters with too many nodes or with nested
+// comprehensions, like the macros all, exists, map and filter.
+func checkFilterCost(expr *exprpb.Expr) error {
+	nodes := 0
+	var walk func(e *exprpb.Expr, inComprehension bool) error
+	walk = func(e *exprpb.Expr, inComprehension bool) error {
+		if e == nil {
+			return nil
+		}
+		if nodes++; nodes > maxFilterNodes {
+			return status.Errorf(codes.InvalidArgument, "filter has more than %d nodes", maxFilterNodes)
+		}
+		var children []*exprpb.Expr
+		switch k := e.ExprKind.(type) {
+		case *exprpb.Expr_SelectExpr:
+			children = append(children, k.SelectExpr.Operand)
+		case *exprpb.Expr_CallExpr:
+			children = append(children, k.CallExpr.Target)
+			children = append(children, k.CallExpr.Args...)
+		case *exprpb.Expr_ListExpr:
+			children = append(children, k.ListExpr.Elements...)
+		case *exprpb.Expr_StructExpr:
+			for _, entry := range k.StructExpr.Entries {
+				children = append(children, entry.GetMapKey(), entry.Value)
+			}
+		case *exprpb.Expr_ComprehensionExpr:
+			if inComprehension {
+				return status.Error(codes.InvalidArgument, "filter has nested comprehensions")
+			}
+			c := k.ComprehensionExpr
+			if err := walk(c.IterRange, false); err != nil {
+				return err
+			}
+			for _, child := range []*exprpb.Expr{c.AccuInit, c.LoopCondition, c.LoopStep, c.Result} {
+				if err := walk(child, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, child := range children {
+			if err := walk(child, inComprehension); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(expr, false)
+}
+
+// match reports whether the record matches, the value is only decoded when
+// the filter looks at json.
+func (f *filter) match(record *api.Record) bool {
+	headers := make(map[string][]byte, len(record.Headers))
+	for _, h := range record.Headers {
+		headers[h.Key] = h.Value
+	}
+	out, _, err := f.program.Eval(map[string]interface{}{
+		"offset":    record.Offset,
+		"key":       record.Key,
+		"value":     record.Value,
+		"headers":   headers,
+		"timestamp": timestamppb.New(time.Unix(0, record.TimestampMs*int64(time.Millisecond))),
+		"json": func() interface{} {
+			v := &structpb.Value{}
+			if err := protojson.Unmarshal(record.Value, v); err != nil {
+				return structpb.NullValue_NULL_VALUE
+			}
+			return v
+		},
+	})
+	if err != nil {
+		return false
+	}
+	matched, ok := out.Value().(bool)
+	return ok && matched
+}
@@ -0,0 +1,353 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewHTTPHandler serves a JSON API over the same log, authorizing requests
+// with the config's Authorizer and the subject the client authenticates as:
+//
+//	POST /v1/records          produces the ProduceRequest in the body
+//	GET  /v1/records/{offset} consumes the record at the offset
+//	GET  /v1/records          streams records as NDJSON, or as server-sent
+//	                          events when the client accepts text/event-stream
+//	GET  /v1/offsets          describes the log like GetLogInfo
+//	GET  /v1/servers          lists the cluster's servers
+//
+// Messages are encoded like protobuf's JSON mapping with the fields' proto
+// names, and the query parameters are named after ConsumeRequest's fields.
+func NewHTTPHandler(config *Config) (http.Handler, error) {
+	srv, err := newgrpcServer(config)
+	if err != nil {
+		return nil, err
+	}
+	h := &httpHandler{srv: srv}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/records", h.produce)
+	mux.HandleFunc("GET /v1/records/{offset}", h.consume)
+	mux.HandleFunc("GET /v1/records", h.consumeStream)
+	mux.HandleFunc("GET /v1/offsets", h.getLogInfo)
+	mux.HandleFunc("GET /v1/servers", h.getServers)
+	return h.authenticate(mux), nil
+}
+
+type httpHandler struct {
+	srv *grpcServer
+}
+
+// maxRequestBytes caps produce bodies like gRPC's default receive limit.
+const maxRequestBytes = 4 << 20
+
+var (
+	marshaler   = protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}
+	unmarshaler = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+// authenticate puts the subject the client authenticates as, with its
+// certificate or its Authorization header, in the request's context like the
+// gRPC server's interceptors do.
+func (h *httpHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := auth.Credentials{}
+		if r.TLS != nil {
+			creds.VerifiedChains = r.TLS.VerifiedChains
+		}
+		if header := r.Header.Get("Authorization"); header != "" {
+			creds.Scheme, creds.Token = parseAuthorization(header)
+		}
+		subject, err := h.srv.authenticateCredentials(r.Context(), creds)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), subjectContextKey{}, subject)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *httpHandler) produce(w http.ResponseWriter, r *http.Request) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		code := codes.InvalidArgument
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			code = codes.ResourceExhausted
+		}
+		writeError(w, status.Error(code, err.Error()))
+		return
+	}
+	req := &api.ProduceRequest{}
+	if err := unmarshaler.Unmarshal(b, req); err != nil {
+		writeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	res, err := h.srv.Produce(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, res)
+}
+
+func (h *httpHandler) consume(w http.ResponseWriter, r *http.Request) {
+	req, err := consumeRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Offset, err = strconv.ParseUint(r.PathValue("offset"), 10, 64); err != nil {
+		writeError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	res, err := h.srv.Consume(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, res)
+}
+
+// consumeStream streams the records through the gRPC server's ConsumeStream.
+// Writes block once the client stops reading, which holds the stream back.
+func (h *httpHandler) consumeStream(w http.ResponseWriter, r *http.Request) {
+	req, err := consumeRequest(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	// the request has been read, so the server's read timeout mustn't cut
+	// the stream short
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+	stream := &httpConsumeStream{
+		ctx: r.Context(),
+		w:   w,
+		sse: strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	contentType := "application/x-ndjson"
+	if stream.sse {
+		contentType = "text/event-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := h.srv.ConsumeStream(req, stream); err != nil {
+		// the error can only go in the body once records were sent
+		if !stream.sent {
+			writeError(w, err)
+			return
+		}
+		_ = stream.writeError(err)
+	}
+}
+
+func (h *httpHandler) getLogInfo(w http.ResponseWriter, r *http.Request) {
+	segments, err := queryBool(r, "segments")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	res, err := h.srv.GetLogInfo(r.Context(), &api.GetLogInfoRequest{
+		Segments: segments,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, res)
+}
+
+func (h *httpHandler) getServers(w http.ResponseWriter, r *http.Request) {
+	res, err := h.srv.GetServers(r.Context(), &api.GetServersRequest{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeMessage(w, res)
+}
+
+// consumeRequest reads the ConsumeRequest from the query.
+func consumeRequest(r *http.Request) (*api.ConsumeRequest, error) {
+	req := &api.ConsumeRequest{}
+	q := r.URL.Query()
+	var err error
+	if req.Offset, err = queryUint(q.Get("offset"), 64); err != nil {
+		return nil, err
+	}
+	maxWait, err := queryUint(q.Get("max_wait_ms"), 32)
+	if err != nil {
+		return nil, err
+	}
+	req.MaxWaitMs = uint32(maxWait)
+	maxRecords, err := queryUint(q.Get("max_records"), 32)
+	if err != nil {
+		return nil, err
+	}
+	req.MaxRecords = uint32(maxRecords)
+	if req.MaxBytes, err = queryUint(q.Get("max_bytes"), 64); err != nil {
+		return nil, err
+	}
+	if start := q.Get("start"); start != "" {
+		v, ok := api.Start_value["START_"+strings.ToUpper(start)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown start: %s", start)
+		}
+		req.Start = api.Start(v)
+	}
+	if q.Has("end_offset") {
+		end, err := queryUint(q.Get("end_offset"), 64)
+		if err != nil {
+			return nil, err
+		}
+		req.EndOffset = &end
+	}
+	req.Filter = q.Get("filter")
+	return req, nil
+}
+
+func queryUint(v string, bitSize int) (uint64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return n, nil
+}
+
+func queryBool(r *http.Request, key string) (bool, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return b, nil
+}
+
+// httpConsumeStream sends ConsumeStream's responses down the HTTP response,
+// a JSON message per line or per event.
+type httpConsumeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	w    http.ResponseWriter
+	sse  bool
+	sent bool
+}
+
+func (s *httpConsumeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *httpConsumeStream) Send(res *api.ConsumeResponse) error {
+	b, err := marshaler.Marshal(res)
+	if err != nil {
+		return err
+	}
+	s.sent = true
+	return s.write("", b)
+}
+
+func (s *httpConsumeStream) writeError(err error) error {
+	b, err := json.Marshal(errorBody(err))
+	if err != nil {
+		return err
+	}
+	return s.write("error", b)
+}
+
+func (s *httpConsumeStream) write(event string, b []byte) error {
+	var err error
+	switch {
+	case !s.sse:
+		_, err = fmt.Fprintf(s.w, "%s\n", b)
+	case event != "":
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, b)
+	default:
+		_, err = fmt.Fprintf(s.w, "data: %s\n\n", b)
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func writeMessage(w http.ResponseWriter, m proto.Message) {
+	b, err := marshaler.Marshal(m)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	code := httpStatus(status.Code(err))
+	if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+		// the log's out of range errors carry 404 rather than a gRPC code
+		code = http.StatusNotFound
+	}
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(errorBody(err))
+}
+
+type httpError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func errorBody(err error) map[string]httpError {
+	st := status.Convert(err)
+	return map[string]httpError{"error": {
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}}
+}
+
+// httpStatus maps the gRPC status codes the server returns to HTTP's.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.ResourceExhausted:
+		return http.StatusRequestEntityTooLarge
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
+	"github.com/madalosso/proglog/internal/config"
+	"github.com/madalosso/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	clog, err := log.NewLog(t.TempDir(), log.Config{})
+	require.NoError(t, err)
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}
+	handler, err := NewHTTPHandler(cfg)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.TLS, err = config.SetupTLSConfig(config.TLSConfig{
+		CertFile: config.ServerCertFile,
+		KeyFile:  config.ServerKeyFile,
+		CAFile:   config.CAFile,
+		Server:   true,
+	})
+	require.NoError(t, err)
+	srv.StartTLS()
+	defer srv.Close()
+
+	newClient := func(crtPath, keyPath string) *http.Client {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile: crtPath,
+			KeyFile:  keyPath,
+			CAFile:   config.CAFile,
+		})
+		require.NoError(t, err)
+		tlsConfig.ServerName = "127.0.0.1"
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+	root := newClient(config.RootClientCertFile, config.RootClientKeyFile)
+	nobody := newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+
+	for i, value := range []string{"first", "second", "third"} {
+		body := fmt.Sprintf(`{"record": {"value": %s}}`, jsonBytes(value))
+		res, err := root.Post(srv.URL+"/v1/records", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		produce := &api.ProduceResponse{}
+		decode(t, res, http.StatusOK, produce)
+		require.Equal(t, uint64(i), produce.Offset)
+	}
+
+	// bodies past gRPC's receive limit are refused
+	body := fmt.Sprintf(`{"record": {"value": %s}}`, jsonBytes(strings.Repeat("a", maxRequestBytes)))
+	res, err := root.Post(srv.URL+"/v1/records", "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	res.Body.Close()
+
+	res, err = root.Get(srv.URL + "/v1/records/1")
+	require.NoError(t, err)
+	consume := &api.ConsumeResponse{}
+	decode(t, res, http.StatusOK, consume)
+	require.Equal(t, []byte("second"), consume.Record.Value)
+
+	res, err = root.Get(srv.URL + "/v1/records/3")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res.Body.Close()
+
+	// NDJSON, a batch per line, ending at the end offset
+	res, err = root.Get(srv.URL + "/v1/records?start=earliest&end_offset=2&max_records=2")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+	var offsets []uint64
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		batch := &api.ConsumeResponse{}
+		require.NoError(t, protojson.Unmarshal(scanner.Bytes(), batch))
+		for _, record := range batch.Records {
+			offsets = append(offsets, record.Offset)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	res.Body.Close()
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+
+	// server-sent events
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/records?offset=2&end_offset=2", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err = root.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	event := strings.TrimSpace(string(b))
+	require.True(t, strings.HasPrefix(event, "data: "))
+	consume = &api.ConsumeResponse{}
+	require.NoError(t, protojson.Unmarshal([]byte(strings.TrimPrefix(event, "data: ")), consume))
+	require.Equal(t, []byte("third"), consume.Record.Value)
+
+	res, err = root.Get(srv.URL + "/v1/offsets?segments=true")
+	require.NoError(t, err)
+	info := &api.GetLogInfoResponse{}
+	decode(t, res, http.StatusOK, info)
+	require.Equal(t, uint64(2), info.HighestOffset)
+	require.NotEmpty(t, info.Segments)
+
+	res, err = nobody.Get(srv.URL + "/v1/records/0")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+	var errBody struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&errBody))
+	res.Body.Close()
+	require.Equal(t, "PermissionDenied", errBody.Error.Code)
+}
+
+// jsonBytes encodes the value like protobuf's JSON mapping encodes bytes.
+func jsonBytes(value string) string {
+	b, _ := json.Marshal([]byte(value))
+	return string(b)
+}
+
+func decode(t *testing.T, res *http.Response, code int, m proto.Message) {
+	t.Helper()
+	defer res.Body.Close()
+	require.Equal(t, code, res.StatusCode)
+	b, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, protojson.Unmarshal(b, m))
+}
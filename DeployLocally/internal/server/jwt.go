@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/madalosso/proglog/internal/auth"
+)
+
+type JWTConfig struct {
+	// JWKSFile holds the keys the tokens are signed with as a JSON Web Key
+	// Set, like the one an OIDC provider serves at its jwks_uri.
+	JWKSFile string
+	// Issuer and Audience are the iss and aud the tokens must have, they
+	// aren't checked when empty.
+	Issuer   string
+	Audience string
+	// SubjectClaim is the claim holding the subject, "sub" when empty.
+	SubjectClaim string
+}
+
+const defaultSubjectClaim = "sub"
+
+// jwtAlgorithms are the signature algorithms tokens are accepted with, all
+// of them asymmetric as the key set is public.
+var jwtAlgorithms = map[string]bool{
+	string(jose.RS256): true, string(jose.RS384): true, string(jose.RS512): true,
+	string(jose.PS256): true, string(jose.PS384): true, string(jose.PS512): true,
+	string(jose.ES256): true, string(jose.ES384): true, string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+// JWTAuthenticator authenticates clients by the JWTs they send as bearer
+// tokens, like OIDC ID tokens, signed by one of the keys in the config's key
+// set. The keys are read once, restart the server to rotate them.
+type JWTAuthenticator struct {
+	config JWTConfig
+	keys   jose.JSONWebKeySet
+}
+
+var _ auth.Authenticator = (*JWTAuthenticator)(nil)
+
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	b, err := os.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	keys := jose.JSONWebKeySet{}
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", config.JWKSFile, err)
+	}
+	for i, key := range keys.Keys {
+		// only ever verify with the public keys, even if given private ones
+		if keys.Keys[i] = key.Public(); !keys.Keys[i].Valid() {
+			return nil, fmt.Errorf("%s: key %q isn't an asymmetric key", config.JWKSFile, key.KeyID)
+		}
+	}
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = defaultSubjectClaim
+	}
+	return &JWTAuthenticator{config: config, keys: keys}, nil
+}
+
+func (a *JWTAuthenticator) Authenticate(_ context.Context, creds auth.Credentials) (string, error) {
+	// compact JWS have three parts, other bearer tokens are left to the
+	// other authenticators
+	if !strings.EqualFold(creds.Scheme, "Bearer") || strings.Count(creds.Token, ".") != 2 {
+		return "", auth.ErrNoCredentials
+	}
+	token, err := jwt.ParseSigned(creds.Token)
+	if err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	if len(token.Headers) != 1 {
+		return "", errors.New("invalid token: expected one signature")
+	}
+	key, err := a.key(token.Headers[0])
+	if err != nil {
+		return "", err
+	}
+	var claims jwt.Claims
+	var all map[string]interface{}
+	if err := token.Claims(key, &claims, &all); err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	if claims.Expiry == nil {
+		return "", errors.New("invalid token: no expiry")
+	}
+	expected := jwt.Expected{Issuer: a.config.Issuer, Time: time.Now()}
+	if a.config.Audience != "" {
+		expected.Audience = jwt.Audience{a.config.Audience}
+	}
+	if err := claims.ValidateWithLeeway(expected, jwt.DefaultLeeway); err != nil {
+		return "", fmt.Errorf("invalid token: %w", err)
+	}
+	subject, _ := all[a.config.SubjectClaim].(string)
+	if subject == "" {
+		return "", fmt.Errorf("invalid token: no %s claim", a.config.SubjectClaim)
+	}
+	return subject, nil
+}
+
+// key is the key the token's signature is checked with, the one its header
+// names, or the only one there is when it names none.
+func (a *JWTAuthenticator) key(header jose.Header) (jose.JSONWebKey, error) {
+	if !jwtAlgorithms[header.Algorithm] {
+		return jose.JSONWebKey{}, fmt.Errorf("invalid token: unsupported algorithm %q", header.Algorithm)
+	}
+	var keys []jose.JSONWebKey
+	if header.KeyID != "" {
+		keys = a.keys.Key(header.KeyID)
+	} else if len(a.keys.Keys) == 1 {
+		keys = a.keys.Keys
+	}
+	for _, key := range keys {
+		if (key.Use == "" || key.Use == "sig") &&
+			(key.Algorithm == "" || key.Algorithm == header.Algorithm) {
+			return key, nil
+		}
+	}
+	return jose.JSONWebKey{}, fmt.Errorf("invalid token: unknown key %q", header.KeyID)
+}
@@ -8,6 +8,7 @@ import (
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	api "github.com/madalosso/proglog/api/v1"
+	"github.com/madalosso/proglog/internal/auth"
 
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	// grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
@@ -17,20 +18,33 @@ import (
 	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"google.golang.org/protobuf/proto"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
-	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type Config struct {
-	CommitLog   CommitLog
-	Authorizer  Authorizer
-	GetServerer GetServerer
+	CommitLog    CommitLog
+	Authorizer   Authorizer
+	GetServerer  GetServerer
+	ClusterAdmin ClusterAdmin
+	Keyring      Keyring
+	// Authenticators tell who clients are, in order, the first one knowing
+	// a client's credentials deciding. Put the token authenticators first,
+	// clients behind TLS-terminating proxies with client certificates of
+	// their own show up with the proxy's. Clients authenticate with their
+	// certificates' common name when there are none.
+	Authenticators []auth.Authenticator
+	// Topic is the name ACLs know the log by, as topics/<topic>, "proglog"
+	// when empty.
+	Topic string
+	// MaxConsumeWait caps how long Consume waits for a record that's yet
+	// to be appended, 30s when 0.
+	MaxConsumeWait time.Duration
 }
 
 type CommitLog interface {
@@ -38,20 +52,66 @@ type CommitLog interface {
 	Read(uint64) (*api.Record, error)
 }
 
+// AckingLog is implemented by commit logs that can answer producers before
+// their records are committed. Other logs always ack once committed.
+type AckingLog interface {
+	AppendAcks(*api.Record, api.Acks) (uint64, api.Acks, error)
+}
+
+// WaitingLog is implemented by commit logs that tell when records get
+// appended, other logs are polled.
+type WaitingLog interface {
+	Wait(ctx context.Context, offset uint64) error
+}
+
+// StartingLog is implemented by commit logs that resolve the earliest and
+// latest start positions, consumers can only start at given offsets on
+// other logs.
+type StartingLog interface {
+	StartOffset(start api.Start, offset uint64) (uint64, error)
+}
+
+// InfoLog is implemented by commit logs that describe themselves and their
+// segments.
+type InfoLog interface {
+	GetLogInfo() (*api.GetLogInfoResponse, error)
+}
+
+const (
+	defaultMaxConsumeWait = 30 * time.Second
+	pollInterval          = 100 * time.Millisecond
+)
+
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
 const (
-	objectWildcard = "*"
+	defaultTopic   = "proglog"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	describeAction = "describe"
+	adminAction    = "admin"
 )
 
+// topicObject is the object ACLs grant access to the log on.
+func (c *Config) topicObject() string {
+	if c.Topic == "" {
+		return "topics/" + defaultTopic
+	}
+	return "topics/" + c.Topic
+}
+
+// adminObject is the object ACLs grant access to an admin endpoint on.
+func adminObject(endpoint string) string {
+	return "admin/" + endpoint
+}
+
 // Note: Very interesting line: This is a compile-time assertion
 // to make sure that the definition of grpcServer
 // matches what is being imported by the api
 var _ api.LogServer = (*grpcServer)(nil)
+var _ api.AdminServer = (*adminServer)(nil)
 
 func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server, error) {
 	logger := zap.L().Named("server")
@@ -76,12 +136,12 @@ func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server,
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
 			grpc_ctxtags.StreamServerInterceptor(),
 			grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-			grpc_auth.StreamServerInterceptor(authenticate),
+			grpc_auth.StreamServerInterceptor(config.authenticate),
 		)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
 			grpc_ctxtags.UnaryServerInterceptor(),
 			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
+			grpc_auth.UnaryServerInterceptor(config.authenticate),
 		)),
 		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 	)
@@ -97,6 +157,9 @@ func NewGRPCServer(config *Config, grpcOpts ...grpc.ServerOption) (*grpc.Server,
 		return nil, err
 	}
 	api.RegisterLogServer(gsrv, srv)
+	if config.ClusterAdmin != nil {
+		api.RegisterAdminServer(gsrv, &adminServer{Config: config})
+	}
 	return gsrv, nil
 }
 
@@ -129,59 +192,257 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 
 	}
 }
+
+// ConsumeStream sends the records from the requested start on, waiting for
+// each record that's yet to be appended for as long as the stream is open.
+// The stream ends once the record at the end offset is sent.
+// Batches are only read once the previous one is sent, so slow consumers
+// hold the stream back through gRPC's flow control.
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+	f, err := newFilter(req.Filter)
+	if err != nil {
+		return err
+	}
+	offset, err := s.startOffset(req)
+	if err != nil {
+		return err
+	}
+	maxRecords := int(req.MaxRecords)
+	if maxRecords == 0 {
+		maxRecords = 1
+	}
 	for {
-		select {
-		case <-stream.Context().Done():
+		if req.EndOffset != nil && offset > *req.EndOffset {
 			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case api.ErrOffsetOutOfRange:
+		}
+		if err := s.Authorizer.Authorize(
+			subject(ctx),
+			s.topicObject(),
+			consumeAction,
+		); err != nil {
+			return err
+		}
+		records, next, err := s.readBatch(ctx, offset, maxRecords, req, f)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		res := &api.ConsumeResponse{NextOffset: next}
+		if req.MaxRecords > 0 {
+			res.Records = records
+		} else if len(records) > 0 {
+			res.Record = records[0]
+		}
+		if err = stream.Send(res); err != nil {
+			return err
+		}
+		offset = next
+	}
+}
+
+// maxFilterSkip is how many records a filtering stream skips before it sends
+// what it has, if only to report its progress.
+const maxFilterSkip = 1000
+
+// readBatch reads up to maxRecords records matching the filter from the
+// offset on, and returns the offset past the last record it read. It waits
+// for the first record for as long as the stream is open, then for the
+// request's max wait for the batch to fill. It returns without records when
+// it skipped records and caught up with the log, or skipped maxFilterSkip
+// records.
+func (s *grpcServer) readBatch(
+	ctx context.Context,
+	offset uint64,
+	maxRecords int,
+	req *api.ConsumeRequest,
+	f *filter,
+) ([]*api.Record, uint64, error) {
+	maxWait := time.Duration(req.MaxWaitMs) * time.Millisecond
+	if maxWait > s.maxConsumeWait() {
+		maxWait = s.maxConsumeWait()
+	}
+
+	var records []*api.Record
+	var size uint64
+	var filled time.Time
+	waited := false
+	next, skipped := offset, 0
+	for len(records) < maxRecords {
+		if req.EndOffset != nil && next > *req.EndOffset {
+			break
+		}
+		record, err := s.CommitLog.Read(next)
+		switch err.(type) {
+		case nil:
+			waited = false
+			if f != nil && !f.match(record) {
+				next++
+				if skipped++; skipped >= maxFilterSkip {
+					return records, next, nil
+				}
 				continue
-			default:
-				return err
 			}
-			if err = stream.Send(res); err != nil {
-				return err
+			recordSize := uint64(proto.Size(record))
+			if len(records) > 0 && req.MaxBytes > 0 && size+recordSize > req.MaxBytes {
+				return records, next, nil
 			}
-			req.Offset++
+			records = append(records, record)
+			size += recordSize
+			next++
+			continue
+		case api.ErrOffsetOutOfRange:
+		default:
+			return nil, 0, err
+		}
+
+		if len(records) == 0 {
+			if skipped > 0 {
+				return nil, next, nil
+			}
+			// the record was appended but it's out of range all the same,
+			// it's before the start of the log
+			if waited {
+				return nil, 0, err
+			}
+			if err := s.waitForRecord(ctx, next, 0); err != nil {
+				return nil, 0, err
+			}
+			waited = true
+			filled = time.Now().Add(maxWait)
+			continue
+		}
+		if filled.IsZero() {
+			filled = time.Now().Add(maxWait)
+		}
+		wait := time.Until(filled)
+		if wait <= 0 || s.waitForRecord(ctx, next, wait) != nil {
+			return records, next, nil
 		}
 	}
+	return records, next, nil
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (
 	*api.ProduceResponse, error) {
 	if err := s.Authorizer.Authorize(
 		subject(ctx),
-		objectWildcard,
+		s.topicObject(),
 		produceAction,
 	); err != nil {
 		return nil, err
 	}
+	if req.Record == nil {
+		req.Record = &api.Record{}
+	}
+	if req.Record.TimestampMs == 0 {
+		// Kafka's and Redis' clients stamp their records, gRPC's and the
+		// JSON API's needn't
+		req.Record.TimestampMs = time.Now().UnixMilli()
+	}
+	if l, ok := s.CommitLog.(AckingLog); ok {
+		offset, acks, err := l.AppendAcks(req.Record, req.Acks)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ProduceResponse{Offset: offset, Acks: acks}, nil
+	}
 	offset, err := s.CommitLog.Append(req.Record)
 	if err != nil {
 		return nil, err
 	}
-	return &api.ProduceResponse{Offset: offset}, nil
+	return &api.ProduceResponse{Offset: offset, Acks: api.Acks_ACKS_QUORUM}, nil
 }
 
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
+	if req.Filter != "" {
+		return nil, status.Error(codes.InvalidArgument, "only ConsumeStream filters records")
+	}
 	if err := s.Authorizer.Authorize(
 		subject(ctx),
-		objectWildcard,
+		s.topicObject(),
 		consumeAction,
 	); err != nil {
 		return nil, err
 	}
-	record, err := s.CommitLog.Read(req.Offset)
+	offset, err := s.startOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	record, err := s.CommitLog.Read(offset)
+	if _, ok := err.(api.ErrOffsetOutOfRange); ok && req.MaxWaitMs > 0 {
+		maxWait := time.Duration(req.MaxWaitMs) * time.Millisecond
+		if maxWait > s.maxConsumeWait() {
+			maxWait = s.maxConsumeWait()
+		}
+		if s.waitForRecord(ctx, offset, maxWait) == nil {
+			record, err = s.CommitLog.Read(offset)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &api.ConsumeResponse{Record: record}, nil
 }
 
+// startOffset resolves the offset the request starts consuming at.
+func (s *grpcServer) startOffset(req *api.ConsumeRequest) (uint64, error) {
+	if req.Start == api.Start_START_OFFSET {
+		return req.Offset, nil
+	}
+	l, ok := s.CommitLog.(StartingLog)
+	if !ok {
+		return 0, status.Errorf(
+			codes.Unimplemented,
+			"the log can't start consuming at %s",
+			req.Start,
+		)
+	}
+	offset, err := l.StartOffset(req.Start, req.Offset)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return offset, nil
+}
+
+// waitForRecord waits until the record at the offset is appended, for at
+// most maxWait unless it's 0.
+func (s *grpcServer) waitForRecord(
+	ctx context.Context,
+	offset uint64,
+	maxWait time.Duration,
+) error {
+	if maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxWait)
+		defer cancel()
+	}
+	if l, ok := s.CommitLog.(WaitingLog); ok {
+		return l.Wait(ctx, offset)
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.CommitLog.Read(offset); err == nil {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *grpcServer) maxConsumeWait() time.Duration {
+	if s.MaxConsumeWait == 0 {
+		return defaultMaxConsumeWait
+	}
+	return s.MaxConsumeWait
+}
+
 func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
 	servers, err := s.GetServerer.GetServers()
 	if err != nil {
@@ -190,26 +451,182 @@ func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest)
 	return &api.GetServersResponse{Servers: servers}, nil
 }
 
+// GetLogInfo describes the log, the segments are only described to admins.
+func (s *grpcServer) GetLogInfo(ctx context.Context, req *api.GetLogInfoRequest) (*api.GetLogInfoResponse, error) {
+	object, action := s.topicObject(), describeAction
+	if req.Segments {
+		object, action = adminObject("GetLogInfo"), adminAction
+	}
+	if err := s.Authorizer.Authorize(
+		subject(ctx),
+		object,
+		action,
+	); err != nil {
+		return nil, err
+	}
+	l, ok := s.CommitLog.(InfoLog)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "the log can't describe itself")
+	}
+	res, err := l.GetLogInfo()
+	if err != nil {
+		return nil, err
+	}
+	if !req.Segments {
+		res.Segments = nil
+	}
+	return res, nil
+}
+
 type GetServerer interface {
 	GetServers() ([]*api.Server, error)
 }
 
-func authenticate(ctx context.Context) (context.Context, error) {
-	peer, ok := peer.FromContext(ctx)
-	if !ok {
-		return ctx, status.New(
-			codes.Unknown,
-			"couldn't find peer info",
-		).Err()
-	}
-	if peer.AuthInfo == nil {
-		// ???
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
-	}
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
-	return ctx, nil
+type ClusterAdmin interface {
+	TransferLeadership(id string) error
+	AddVoter(id, addr string) error
+	Leave(id string) error
+	DemoteVoter(id string) error
+	ListPeers() (*api.ListPeersResponse, error)
+	Stats() map[string]string
+}
+
+// Keyring manages the keys encrypting the cluster's gossip.
+type Keyring interface {
+	InstallKey(key string) error
+	UseKey(key string) error
+	RemoveKey(key string) error
+	ListKeys() (keys map[string]int, numServers int, err error)
+}
+
+type adminServer struct {
+	api.UnimplementedAdminServer
+	*Config
+}
+
+func (s *adminServer) TransferLeadership(ctx context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	if err := s.authorize(ctx, "TransferLeadership"); err != nil {
+		return nil, err
+	}
+	if err := s.ClusterAdmin.TransferLeadership(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+func (s *adminServer) AddVoter(ctx context.Context, req *api.AddVoterRequest) (*api.AddVoterResponse, error) {
+	if err := s.authorize(ctx, "AddVoter"); err != nil {
+		return nil, err
+	}
+	if err := s.ClusterAdmin.AddVoter(req.Id, req.RpcAddr); err != nil {
+		return nil, err
+	}
+	return &api.AddVoterResponse{}, nil
+}
+
+func (s *adminServer) RemoveServer(ctx context.Context, req *api.RemoveServerRequest) (*api.RemoveServerResponse, error) {
+	if err := s.authorize(ctx, "RemoveServer"); err != nil {
+		return nil, err
+	}
+	if err := s.ClusterAdmin.Leave(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.RemoveServerResponse{}, nil
+}
+
+func (s *adminServer) DemoteVoter(ctx context.Context, req *api.DemoteVoterRequest) (*api.DemoteVoterResponse, error) {
+	if err := s.authorize(ctx, "DemoteVoter"); err != nil {
+		return nil, err
+	}
+	if err := s.ClusterAdmin.DemoteVoter(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.DemoteVoterResponse{}, nil
+}
+
+func (s *adminServer) ListPeers(ctx context.Context, req *api.ListPeersRequest) (*api.ListPeersResponse, error) {
+	if err := s.authorize(ctx, "ListPeers"); err != nil {
+		return nil, err
+	}
+	return s.ClusterAdmin.ListPeers()
+}
+
+func (s *adminServer) GetStats(ctx context.Context, req *api.GetStatsRequest) (*api.GetStatsResponse, error) {
+	if err := s.authorize(ctx, "GetStats"); err != nil {
+		return nil, err
+	}
+	return &api.GetStatsResponse{Stats: s.ClusterAdmin.Stats()}, nil
+}
+
+func (s *adminServer) InstallKey(ctx context.Context, req *api.InstallKeyRequest) (*api.InstallKeyResponse, error) {
+	if err := s.authorize(ctx, "InstallKey"); err != nil {
+		return nil, err
+	}
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if err := s.Keyring.InstallKey(req.Key); err != nil {
+		return nil, err
+	}
+	return &api.InstallKeyResponse{}, nil
+}
+
+func (s *adminServer) UseKey(ctx context.Context, req *api.UseKeyRequest) (*api.UseKeyResponse, error) {
+	if err := s.authorize(ctx, "UseKey"); err != nil {
+		return nil, err
+	}
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if err := s.Keyring.UseKey(req.Key); err != nil {
+		return nil, err
+	}
+	return &api.UseKeyResponse{}, nil
+}
+
+func (s *adminServer) RemoveKey(ctx context.Context, req *api.RemoveKeyRequest) (*api.RemoveKeyResponse, error) {
+	if err := s.authorize(ctx, "RemoveKey"); err != nil {
+		return nil, err
+	}
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	if err := s.Keyring.RemoveKey(req.Key); err != nil {
+		return nil, err
+	}
+	return &api.RemoveKeyResponse{}, nil
+}
+
+func (s *adminServer) ListKeys(ctx context.Context, req *api.ListKeysRequest) (*api.ListKeysResponse, error) {
+	if err := s.authorize(ctx, "ListKeys"); err != nil {
+		return nil, err
+	}
+	if s.Keyring == nil {
+		return nil, errNoKeyring
+	}
+	keys, numServers, err := s.Keyring.ListKeys()
+	if err != nil {
+		return nil, err
+	}
+	res := &api.ListKeysResponse{
+		Keys:       make(map[string]int32, len(keys)),
+		NumServers: int32(numServers),
+	}
+	for key, n := range keys {
+		res.Keys[key] = int32(n)
+	}
+	return res, nil
+}
+
+// errNoKeyring is returned when the server doesn't manage gossip keys.
+var errNoKeyring = status.Error(codes.FailedPrecondition, "no gossip keyring")
+
+func (s *adminServer) authorize(ctx context.Context, endpoint string) error {
+	return s.Authorizer.Authorize(
+		subject(ctx),
+		adminObject(endpoint),
+		adminAction,
+	)
 }
 
 func subject(ctx context.Context) string {
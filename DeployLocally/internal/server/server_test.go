@@ -3,8 +3,11 @@ package server
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -40,24 +43,30 @@ func TestServer(t *testing.T) {
 		"produce/consume a message to/from the log succeeds": testProduceConsume,
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
+		"consume waits for the record":                       testConsumeWait,
+		"consume stream waits for records":                   testConsumeStreamWait,
+		"consume stream sends batches":                       testConsumeStreamBatches,
+		"consume stream from start to end":                   testConsumeStreamStartEnd,
+		"consume stream filters records":                     testConsumeStreamFilter,
+		"get log info":                                       testGetLogInfo,
 		"unauthorized fails":                                 testUnauthorized,
 	}
 	for scenario, fn := range tests {
 		t.Run(scenario, func(t *testing.T) {
-			rootClient, nobodyClient, config, teardown := setupTest(t, nil)
+			rootConn, nobodyConn, config, teardown := setupTest(t, nil)
 			defer teardown()
-			fn(t, rootClient, nobodyClient, config)
+			fn(t, api.NewLogClient(rootConn), api.NewLogClient(nobodyConn), config)
 		})
 	}
 }
 
-func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobodyClient api.LogClient, cfg *Config, teardown func()) {
+func setupTest(t *testing.T, fn func(*Config)) (rootConn *grpc.ClientConn, nobodyConn *grpc.ClientConn, cfg *Config, teardown func()) {
 	t.Helper()
 
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
 
-	newClient := func(crtPath, keyPath string) (*grpc.ClientConn, api.LogClient, []grpc.DialOption) {
+	newClient := func(crtPath, keyPath string) (*grpc.ClientConn, []grpc.DialOption) {
 		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
 			CertFile: crtPath,
 			KeyFile:  keyPath,
@@ -69,17 +78,14 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 		opts := []grpc.DialOption{grpc.WithTransportCredentials(tlsCreds)}
 		conn, err := grpc.Dial(l.Addr().String(), opts...)
 		require.NoError(t, err)
-		client := api.NewLogClient(conn)
-		return conn, client, opts
+		return conn, opts
 	}
 
-	var rootConn *grpc.ClientConn
-	rootConn, rootClient, _ = newClient(
+	rootConn, _ = newClient(
 		config.RootClientCertFile,
 		config.RootClientKeyFile,
 	)
-	var nobodyConn *grpc.ClientConn
-	nobodyConn, nobodyClient, _ = newClient(
+	nobodyConn, _ = newClient(
 		config.NobodyClientCertFile,
 		config.NobodyClientKeyFile,
 	)
@@ -146,7 +152,7 @@ func setupTest(t *testing.T, fn func(*Config)) (rootClient api.LogClient, nobody
 	}()
 
 	// Remove clog? (source material doesn't include it)
-	return rootClient, nobodyClient, cfg, func() {
+	return rootConn, nobodyConn, cfg, func() {
 		server.Stop()
 		rootConn.Close()
 		nobodyConn.Close()
@@ -172,9 +178,12 @@ func testProduceConsume(t *testing.T, client api.LogClient, _ api.LogClient, con
 		ctx,
 		&api.ProduceRequest{
 			Record: logRecord,
+			Acks:   api.Acks_ACKS_LEADER,
 		},
 	)
 	require.NoError(t, err)
+	// a local log only acks once the record's written
+	require.Equal(t, api.Acks_ACKS_QUORUM, produce.Acks)
 
 	consume, err := client.Consume(ctx, &api.ConsumeRequest{
 		Offset: produce.Offset,
@@ -182,6 +191,8 @@ func testProduceConsume(t *testing.T, client api.LogClient, _ api.LogClient, con
 	require.NoError(t, err)
 	require.Equal(t, logRecord.Value, consume.Record.Value)
 	require.Equal(t, logRecord.Offset, consume.Record.Offset)
+	// records are stamped with when they were produced
+	require.InDelta(t, time.Now().UnixMilli(), consume.Record.TimestampMs, float64(time.Minute.Milliseconds()))
 }
 
 func testConsumePastBoundary(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
@@ -247,15 +258,253 @@ func testProduceConsumeStream(t *testing.T, client api.LogClient, _ api.LogClien
 		for i, record := range records {
 			res, err := stream.Recv()
 			require.NoError(t, err)
+			require.NotZero(t, res.Record.TimestampMs)
 			require.Equal(t, res.Record, &api.Record{
-				Value:  record.Value,
-				Offset: uint64(i),
+				Value:       record.Value,
+				Offset:      uint64(i),
+				TimestampMs: res.Record.TimestampMs,
 			})
 
 		}
 	}
 }
 
+func testConsumeWait(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = config.CommitLog.Append(&api.Record{Value: []byte("hello world")})
+	}()
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: 0, MaxWaitMs: 5000})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello world"), consume.Record.Value)
+}
+
+func testConsumeStreamWait(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
+	require.NoError(t, err)
+
+	values := []string{"first", "second"}
+	go func() {
+		for _, value := range values {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = config.CommitLog.Append(&api.Record{Value: []byte(value)})
+		}
+	}()
+	for i, value := range values {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), res.Record.Offset)
+		require.Equal(t, value, string(res.Record.Value))
+	}
+}
+
+func testConsumeStreamBatches(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		_, err := config.CommitLog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:     0,
+		MaxRecords: 2,
+	})
+	require.NoError(t, err)
+	for _, want := range [][]uint64{{0, 1}, {2, 3}, {4}} {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Nil(t, res.Record)
+		require.Equal(t, len(want), len(res.Records))
+		for i, record := range res.Records {
+			require.Equal(t, want[i], record.Offset)
+		}
+	}
+
+	// the batch fills while it waits
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:     5,
+		MaxRecords: 2,
+		MaxWaitMs:  5000,
+	})
+	require.NoError(t, err)
+	go func() {
+		for i := 0; i < 2; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = config.CommitLog.Append(&api.Record{Value: []byte("hello world")})
+		}
+	}()
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res.Records))
+
+	// batches always have a record, however big it is
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:     0,
+		MaxRecords: 10,
+		MaxBytes:   1,
+	})
+	require.NoError(t, err)
+	for i := uint64(0); i < 2; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(res.Records))
+		require.Equal(t, i, res.Records[0].Offset)
+	}
+}
+
+func testConsumeStreamFilter(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	produce := func(value, kind string) {
+		record := &api.Record{Value: []byte(value)}
+		if kind != "" {
+			record.Headers = []*api.Header{{Key: "kind", Value: []byte(kind)}}
+		}
+		_, err := config.CommitLog.Append(record)
+		require.NoError(t, err)
+	}
+	for i := 0; i < 5; i++ {
+		kind := "odd"
+		if i%2 == 0 {
+			kind = "even"
+		}
+		produce(fmt.Sprintf(`{"n": %d}`, i), kind)
+	}
+	// records the filter fails on don't match
+	produce("not json", "")
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:     0,
+		MaxRecords: 10,
+		Filter:     `headers["kind"] == b"even" && json.n > 0.0`,
+	})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(6), res.NextOffset)
+	require.Equal(t, 2, len(res.Records))
+	require.Equal(t, uint64(2), res.Records[0].Offset)
+	require.Equal(t, uint64(4), res.Records[1].Offset)
+
+	// progress is reported without records once the stream catches up
+	produce(`{"n": 7}`, "odd")
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(7), res.NextOffset)
+	require.Empty(t, res.Records)
+
+	// a comprehension over the record's headers is fine
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:     0,
+		MaxRecords: 10,
+		Filter:     `headers.exists(k, k == "kind")`,
+	})
+	require.NoError(t, err)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, 6, len(res.Records))
+
+	for _, filter := range []string{
+		"offset +",
+		"offset + 1u",
+		// too expensive
+		strings.Repeat("1u + ", 100) + "offset > 0u",
+		strings.Repeat(" ", maxFilterLen) + "true",
+		`headers.all(k, headers.exists(j, j == k))`,
+	} {
+		stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{Filter: filter})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Filter: "true"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func testConsumeStreamStartEnd(t *testing.T, client api.LogClient, _ api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		_, err := config.CommitLog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	// everything up to now, then the stream ends
+	end := uint64(2)
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Start:      api.Start_START_EARLIEST,
+		EndOffset:  &end,
+		MaxRecords: 2,
+	})
+	require.NoError(t, err)
+	var offsets []uint64
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		for _, record := range res.Records {
+			offsets = append(offsets, record.Offset)
+		}
+	}
+	require.Equal(t, []uint64{0, 1, 2}, offsets)
+
+	// only new records
+	stream, err = client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Start: api.Start_START_LATEST,
+	})
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = config.CommitLog.Append(&api.Record{Value: []byte("new")})
+	}()
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), res.Record.Offset)
+	require.Equal(t, []byte("new"), res.Record.Value)
+}
+
+func testGetLogInfo(t *testing.T, client api.LogClient, nobody api.LogClient, config *Config) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := config.CommitLog.Append(&api.Record{Value: []byte("hello world")})
+		require.NoError(t, err)
+	}
+
+	info, err := client.GetLogInfo(ctx, &api.GetLogInfoRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), info.LowestOffset)
+	require.Equal(t, uint64(2), info.HighestOffset)
+	require.NotZero(t, info.SegmentCount)
+	require.NotZero(t, info.TotalBytes)
+	require.Empty(t, info.Segments)
+
+	info, err = client.GetLogInfo(ctx, &api.GetLogInfoRequest{Segments: true})
+	require.NoError(t, err)
+	require.Equal(t, int(info.SegmentCount), len(info.Segments))
+	var total uint64
+	for _, segment := range info.Segments {
+		total += segment.StoreSize + segment.IndexSize
+	}
+	require.Equal(t, info.TotalBytes, total)
+	last := info.Segments[len(info.Segments)-1]
+	require.Equal(t, uint64(3), last.NextOffset)
+
+	_, err = nobody.GetLogInfo(ctx, &api.GetLogInfoRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
 func testUnauthorized(
 	t *testing.T,
 	_,
@@ -287,3 +536,99 @@ func testUnauthorized(
 	}
 
 }
+
+func TestAdmin(t *testing.T) {
+	clusterAdmin := &clusterAdmin{}
+	rootConn, nobodyConn, _, teardown := setupTest(t, func(c *Config) {
+		c.ClusterAdmin = clusterAdmin
+	})
+	defer teardown()
+	ctx := context.Background()
+
+	rootClient := api.NewAdminClient(rootConn)
+	_, err := rootClient.AddVoter(ctx, &api.AddVoterRequest{
+		Id:      "1",
+		RpcAddr: "localhost:9002",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1", clusterAdmin.voter)
+
+	_, err = rootClient.DemoteVoter(ctx, &api.DemoteVoterRequest{Id: "1"})
+	require.NoError(t, err)
+	require.Equal(t, "1", clusterAdmin.demoted)
+
+	_, err = rootClient.RemoveServer(ctx, &api.RemoveServerRequest{Id: "1"})
+	require.NoError(t, err)
+	require.Equal(t, "1", clusterAdmin.removed)
+
+	peers, err := rootClient.ListPeers(ctx, &api.ListPeersRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", peers.State)
+	require.Equal(t, uint64(2), peers.Peers[0].Lag)
+
+	stats, err := rootClient.GetStats(ctx, &api.GetStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Leader", stats.Stats["state"])
+
+	// gossip keys aren't managed without a keyring
+	_, err = rootClient.ListKeys(ctx, &api.ListKeysRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	nobodyClient := api.NewAdminClient(nobodyConn)
+	_, err = nobodyClient.TransferLeadership(ctx, &api.TransferLeadershipRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobodyClient.ListPeers(ctx, &api.ListPeersRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+type clusterAdmin struct {
+	voter, removed, demoted string
+}
+
+func (c *clusterAdmin) TransferLeadership(id string) error {
+	return nil
+}
+
+func (c *clusterAdmin) AddVoter(id, addr string) error {
+	c.voter = id
+	return nil
+}
+
+func (c *clusterAdmin) Leave(id string) error {
+	c.removed = id
+	return nil
+}
+
+func (c *clusterAdmin) DemoteVoter(id string) error {
+	c.demoted = id
+	return nil
+}
+
+func (c *clusterAdmin) ListPeers() (*api.ListPeersResponse, error) {
+	return &api.ListPeersResponse{
+		State: "Leader",
+		Peers: []*api.Peer{{Id: "1", Lag: 2}},
+	}, nil
+}
+
+func (c *clusterAdmin) Stats() map[string]string {
+	return map[string]string{"state": "Leader"}
+}
+
+func TestConsumeMaxWait(t *testing.T) {
+	rootConn, _, _, teardown := setupTest(t, func(c *Config) {
+		c.MaxConsumeWait = 50 * time.Millisecond
+	})
+	defer teardown()
+	client := api.NewLogClient(rootConn)
+
+	start := time.Now()
+	_, err := client.Consume(context.Background(), &api.ConsumeRequest{
+		Offset:    0,
+		MaxWaitMs: 10000,
+	})
+	got := status.Code(err)
+	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
+	require.Equal(t, want, got)
+	require.Less(t, time.Since(start), 5*time.Second)
+}